@@ -0,0 +1,152 @@
+// Package apierr defines the errors handlers return. Every error response has the same shape,
+// {"error": {"code": ..., "message": ..., "request_id": ...}}, with a code clients can match on
+// and a message they can show. The underlying cause is only logged.
+package apierr
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type Code string
+
+const (
+	CodeInvalidRequest       Code = "invalid_request"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeTokenExpired         Code = "token_expired"
+	CodeForbidden            Code = "forbidden"
+	CodeNotFound             Code = "not_found"
+	CodeArtistNotFound       Code = "artist_not_found"
+	CodeNoTracks             Code = "no_tracks"
+	CodeSnapshotNotFound     Code = "snapshot_not_found"
+	CodeSnapshotUnrestorable Code = "snapshot_unrestorable"
+	CodeSubscriptionNotFound Code = "subscription_not_found"
+	CodeWebhookNotFound      Code = "webhook_not_found"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeRequestTooLarge      Code = "request_too_large"
+	CodeRefreshTokenMissing  Code = "refresh_token_missing"
+	CodeRateLimited          Code = "rate_limited"
+	CodeInternal             Code = "internal_error"
+	CodeSpotifyError         Code = "spotify_error"
+	CodeSpotifyRateLimited   Code = "spotify_rate_limited"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidRequest:       http.StatusBadRequest,
+	CodeUnauthorized:         http.StatusUnauthorized,
+	CodeTokenExpired:         http.StatusUnauthorized,
+	CodeForbidden:            http.StatusForbidden,
+	CodeNotFound:             http.StatusNotFound,
+	CodeArtistNotFound:       http.StatusNotFound,
+	CodeNoTracks:             http.StatusNotFound,
+	CodeSnapshotNotFound:     http.StatusNotFound,
+	CodeSnapshotUnrestorable: http.StatusConflict,
+	CodeSubscriptionNotFound: http.StatusNotFound,
+	CodeWebhookNotFound:      http.StatusNotFound,
+	CodeMethodNotAllowed:     http.StatusMethodNotAllowed,
+	CodeRequestTooLarge:      http.StatusRequestEntityTooLarge,
+	CodeRefreshTokenMissing:  http.StatusPreconditionFailed,
+	CodeRateLimited:          http.StatusTooManyRequests,
+	CodeInternal:             http.StatusInternalServerError,
+	CodeSpotifyError:         http.StatusBadGateway,
+	// Spotify limits the whole app, not the caller, so this is not a 429
+	CodeSpotifyRateLimited: http.StatusServiceUnavailable,
+}
+
+type Error struct {
+	Code    Code
+	Message string
+	// RetryAfter is sent as the Retry-After header when set
+	RetryAfter time.Duration
+	// Err is the cause. It is logged, never sent to the client.
+	Err error
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap returns an error with the given code and message caused by err. If err already is an
+// *Error it is returned as is, so a specific code (say token_expired from a Spotify call) is
+// not replaced by a generic one on the way up.
+func Wrap(err error, code Code, message string) error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return err
+	}
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// HasCode reports whether err is an *Error with the given code
+func HasCode(err error, code Code) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// From turns any error a handler returned into an *Error. Errors from Fiber itself (unknown
+// route, body too large) keep their status; anything else is an internal error.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return &Error{Code: codeForStatus(fiberErr.Code), Message: fiberErr.Message}
+	}
+	return &Error{Code: CodeInternal, Message: "Internal server error", Err: err}
+}
+
+// Status is the status the client gets for err
+func Status(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return From(err).Status()
+}
+
+func codeForStatus(status int) Code {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		return CodeRequestTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
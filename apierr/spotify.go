@@ -0,0 +1,38 @@
+package apierr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// FromSpotify describes a failed Spotify API response. The response body, which explains the
+// failure, goes in the cause.
+func FromSpotify(resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	cause := fmt.Errorf("spotify %s %s: status %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, body)
+
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		// Usually a bad ID or authorization code that came from the client
+		return &Error{Code: CodeInvalidRequest, Message: "Spotify rejected the request as invalid", Err: cause}
+	case http.StatusUnauthorized:
+		return &Error{Code: CodeTokenExpired, Message: "Spotify access token is invalid or expired", Err: cause}
+	case http.StatusForbidden:
+		return &Error{Code: CodeForbidden, Message: "Spotify refused the request", Err: cause}
+	case http.StatusNotFound:
+		return &Error{Code: CodeNotFound, Message: "Not found on Spotify", Err: cause}
+	case http.StatusTooManyRequests:
+		// Spotify gives the wait in seconds
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &Error{
+			Code:       CodeSpotifyRateLimited,
+			Message:    "Spotify is rate limiting requests, try again later",
+			RetryAfter: time.Duration(seconds) * time.Second,
+			Err:        cause,
+		}
+	}
+	return &Error{Code: CodeSpotifyError, Message: fmt.Sprintf("Spotify returned status %d", resp.StatusCode), Err: cause}
+}
@@ -0,0 +1,60 @@
+package apierr
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromSpotify(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		retryAfter     string
+		wantCode       Code
+		wantStatus     int
+		wantRetryAfter time.Duration
+	}{
+		{"bad request", http.StatusBadRequest, "", CodeInvalidRequest, http.StatusBadRequest, 0},
+		{"expired token", http.StatusUnauthorized, "", CodeTokenExpired, http.StatusUnauthorized, 0},
+		{"forbidden", http.StatusForbidden, "", CodeForbidden, http.StatusForbidden, 0},
+		{"not found", http.StatusNotFound, "", CodeNotFound, http.StatusNotFound, 0},
+		{"rate limited", http.StatusTooManyRequests, "7", CodeSpotifyRateLimited, http.StatusServiceUnavailable, 7 * time.Second},
+		{"rate limited without wait", http.StatusTooManyRequests, "", CodeSpotifyRateLimited, http.StatusServiceUnavailable, 0},
+		{"server error", http.StatusInternalServerError, "", CodeSpotifyError, http.StatusBadGateway, 0},
+		{"unavailable", http.StatusServiceUnavailable, "", CodeSpotifyError, http.StatusBadGateway, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const body = `{"error":{"status":0,"message":"explanation"}}`
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/v1/artists/abc"}},
+			}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			err := FromSpotify(resp)
+
+			if err.Code != tt.wantCode {
+				t.Errorf("code = %s, want %s", err.Code, tt.wantCode)
+			}
+			if err.Status() != tt.wantStatus {
+				t.Errorf("status = %d, want %d", err.Status(), tt.wantStatus)
+			}
+			if err.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("retry after = %s, want %s", err.RetryAfter, tt.wantRetryAfter)
+			}
+			// The body is for the logs, not the client
+			if !strings.Contains(err.Error(), "explanation") || strings.Contains(err.Message, "explanation") {
+				t.Errorf("error %q / message %q should carry the body in the cause only", err.Error(), err.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Package cache stores opaque byte values under string keys, in Redis, in process memory, or
+// both. Callers handle encoding; see handlers/cacheenc.go.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendTiered = "tiered" // memory in front of Redis
+)
+
+var ErrMiss = errors.New("cache miss")
+
+// EntryInfo describes a stored entry without reading it
+type EntryInfo struct {
+	Exists bool
+	Size   int64
+	TTL    time.Duration // 0 if the entry never expires
+}
+
+type Cache interface {
+	// Get returns ErrMiss if the key isn't stored
+	Get(ctx context.Context, key string) ([]byte, error)
+	// GetMany returns a value per key, nil for keys that aren't stored
+	GetMany(ctx context.Context, keys []string) ([][]byte, error)
+	// Set stores value for ttl; 0 means no expiry
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete returns how many of the keys were stored
+	Delete(ctx context.Context, keys ...string) (int64, error)
+	// Keys lists the keys matching a Redis-style glob pattern
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	// Stat describes each key
+	Stat(ctx context.Context, keys []string) ([]EntryInfo, error)
+}
+
+type Options struct {
+	Backend        string
+	MemoryMaxBytes int64
+	FrontTTL       time.Duration // longest a tiered cache keeps an entry in memory
+}
+
+// New builds the configured backend. redisCache may be nil for the memory backend.
+func New(opts Options, redisCache *Redis) (Cache, error) {
+	switch opts.Backend {
+	case BackendRedis:
+		return redisCache, nil
+	case BackendMemory:
+		return NewLRU(opts.MemoryMaxBytes), nil
+	case BackendTiered:
+		return NewTiered(NewLRU(opts.MemoryMaxBytes), redisCache, opts.FrontTTL), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q, expected %s, %s or %s", opts.Backend, BackendRedis, BackendMemory, BackendTiered)
+	}
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"path"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process cache bounded by the total size of its values. Expired entries are
+// dropped when read or when they reach the back of the list.
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // zero if the entry never expires
+}
+
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the live entry for key, dropping it if it has expired. Callers hold mu.
+func (l *LRU) get(key string) *lruEntry {
+	element, found := l.entries[key]
+	if !found {
+		return nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		l.remove(element)
+		return nil
+	}
+	return entry
+}
+
+func (l *LRU) remove(element *list.Element) {
+	entry := l.order.Remove(element).(*lruEntry)
+	delete(l.entries, entry.key)
+	l.size -= int64(len(entry.value))
+}
+
+func (l *LRU) Get(_ context.Context, key string) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := l.get(key)
+	if entry == nil {
+		return nil, ErrMiss
+	}
+	l.order.MoveToFront(l.entries[key])
+	return entry.value, nil
+}
+
+func (l *LRU) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i], _ = l.Get(ctx, key)
+	}
+	return values, nil
+}
+
+func (l *LRU) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	if int64(len(value)) > l.maxBytes {
+		// Would evict everything else and still not fit. Drop the old value, so it isn't
+		// served as if this write had happened.
+		_, _ = l.Delete(context.Background(), key)
+		return nil
+	}
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if element, found := l.entries[key]; found {
+		l.remove(element)
+	}
+	l.entries[key] = l.order.PushFront(entry)
+	l.size += int64(len(value))
+	for l.size > l.maxBytes {
+		l.remove(l.order.Back())
+	}
+	return nil
+}
+
+func (l *LRU) Delete(_ context.Context, keys ...string) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var deleted int64
+	for _, key := range keys {
+		if l.get(key) != nil {
+			l.remove(l.entries[key])
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Keys matches with path.Match, which agrees with Redis globs for keys without slashes
+func (l *LRU) Keys(_ context.Context, pattern string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var keys []string
+	for key := range l.entries {
+		matched, err := path.Match(pattern, key)
+		if err != nil {
+			return nil, err
+		}
+		if matched && l.get(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (l *LRU) Stat(_ context.Context, keys []string) ([]EntryInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	infos := make([]EntryInfo, len(keys))
+	for i, key := range keys {
+		entry := l.get(key)
+		if entry == nil {
+			continue
+		}
+		infos[i] = EntryInfo{Exists: true, Size: int64(len(entry.value))}
+		if !entry.expiresAt.IsZero() {
+			infos[i].TTL = time.Until(entry.expiresAt)
+		}
+	}
+	return infos, nil
+}
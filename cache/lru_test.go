@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	value := func(n int) []byte { return make([]byte, n) }
+
+	type step struct {
+		op    string // set, get or delete
+		key   string
+		size  int           // for set
+		ttl   time.Duration // for set
+		found bool          // for get and delete
+	}
+	tests := []struct {
+		name     string
+		maxBytes int64
+		steps    []step
+		wantKeys []string
+	}{
+		{
+			name:     "set and get",
+			maxBytes: 10,
+			steps:    []step{{op: "set", key: "a", size: 4}, {op: "get", key: "a", found: true}, {op: "get", key: "b"}},
+			wantKeys: []string{"a"},
+		},
+		{
+			name:     "evicts the least recently used",
+			maxBytes: 10,
+			steps: []step{
+				{op: "set", key: "a", size: 4},
+				{op: "set", key: "b", size: 4},
+				{op: "get", key: "a", found: true},
+				{op: "set", key: "c", size: 4},
+				{op: "get", key: "b"},
+			},
+			wantKeys: []string{"a", "c"},
+		},
+		{
+			name:     "overwrite replaces the size",
+			maxBytes: 10,
+			steps: []step{
+				{op: "set", key: "a", size: 8},
+				{op: "set", key: "a", size: 2},
+				{op: "set", key: "b", size: 8},
+				{op: "get", key: "a", found: true},
+			},
+			wantKeys: []string{"a", "b"},
+		},
+		{
+			name:     "oversized value drops the old one",
+			maxBytes: 10,
+			steps: []step{
+				{op: "set", key: "a", size: 4},
+				{op: "set", key: "b", size: 4},
+				{op: "set", key: "a", size: 11},
+				{op: "get", key: "a"},
+			},
+			wantKeys: []string{"b"},
+		},
+		{
+			name:     "expired entries are gone",
+			maxBytes: 10,
+			steps: []step{
+				{op: "set", key: "a", size: 1, ttl: time.Nanosecond},
+				{op: "set", key: "b", size: 1, ttl: time.Hour},
+				{op: "get", key: "a"},
+				{op: "delete", key: "a"},
+			},
+			wantKeys: []string{"b"},
+		},
+		{
+			name:     "delete",
+			maxBytes: 10,
+			steps:    []step{{op: "set", key: "a", size: 1}, {op: "delete", key: "a", found: true}, {op: "delete", key: "a"}},
+			wantKeys: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLRU(tt.maxBytes)
+			for i, s := range tt.steps {
+				switch s.op {
+				case "set":
+					if err := l.Set(ctx, s.key, value(s.size), s.ttl); err != nil {
+						t.Fatalf("step %d: Set: %v", i, err)
+					}
+					if s.ttl > 0 && s.ttl < time.Millisecond {
+						time.Sleep(time.Millisecond)
+					}
+				case "get":
+					_, err := l.Get(ctx, s.key)
+					if found := err == nil; found != s.found {
+						t.Errorf("step %d: Get(%s) found %v, want %v (err %v)", i, s.key, found, s.found, err)
+					}
+				case "delete":
+					n, err := l.Delete(ctx, s.key)
+					if err != nil || (n == 1) != s.found {
+						t.Errorf("step %d: Delete(%s) = %d, %v, want found %v", i, s.key, n, err, s.found)
+					}
+				}
+			}
+
+			keys, err := l.Keys(ctx, "*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(keys)
+			if !slices.Equal(keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if l.size > l.maxBytes {
+				t.Errorf("size %d exceeds max %d", l.size, l.maxBytes)
+			}
+		})
+	}
+}
+
+func TestLRUKeysAndStat(t *testing.T) {
+	ctx := context.Background()
+	l := NewLRU(100)
+	_ = l.Set(ctx, "artist_tracks:a", []byte("12345"), time.Hour)
+	_ = l.Set(ctx, "artist_tracks:b", []byte("1"), 0)
+	_ = l.Set(ctx, "album:c", []byte("1"), 0)
+
+	keys, err := l.Keys(ctx, "artist_tracks:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(keys)
+	if want := []string{"artist_tracks:a", "artist_tracks:b"}; !slices.Equal(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+
+	infos, err := l.Stat(ctx, []string{"artist_tracks:a", "artist_tracks:b", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !infos[0].Exists || infos[0].Size != 5 || infos[0].TTL <= 59*time.Minute || infos[0].TTL > time.Hour {
+		t.Errorf("Stat(a) = %+v, want 5 bytes with about an hour left", infos[0])
+	}
+	if !infos[1].Exists || infos[1].TTL != 0 {
+		t.Errorf("Stat(b) = %+v, want no expiry", infos[1])
+	}
+	if infos[2].Exists {
+		t.Errorf("Stat(missing) = %+v, want not existing", infos[2])
+	}
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Tiered serves reads from memory and falls back to Redis. Entries stay in memory for at most
+// frontTTL, so invalidations on other replicas show up within that time. If Redis fails, the
+// memory tier keeps serving and writes only go there.
+type Tiered struct {
+	front    *LRU
+	back     Cache
+	frontTTL time.Duration
+	backDown atomic.Bool
+}
+
+// backFailed logs the first Redis failure of an outage; the rest would flood the log
+func (t *Tiered) backFailed(ctx context.Context, msg string, err error) {
+	if !t.backDown.Swap(true) {
+		slog.WarnContext(ctx, msg+", further failures are not logged until it recovers", "err", err)
+	}
+}
+
+func (t *Tiered) backOK(ctx context.Context) {
+	if t.backDown.Swap(false) {
+		slog.InfoContext(ctx, "redis cache recovered")
+	}
+}
+
+func NewTiered(front *LRU, back Cache, frontTTL time.Duration) *Tiered {
+	return &Tiered{front: front, back: back, frontTTL: frontTTL}
+}
+
+func (t *Tiered) frontTTLFor(ttl time.Duration) time.Duration {
+	if ttl <= 0 || ttl > t.frontTTL {
+		return t.frontTTL
+	}
+	return ttl
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := t.front.Get(ctx, key); err == nil {
+		return value, nil
+	}
+	value, err := t.back.Get(ctx, key)
+	if err == ErrMiss {
+		t.backOK(ctx)
+		return nil, ErrMiss
+	}
+	if err != nil {
+		t.backFailed(ctx, "redis cache read failed, serving from memory only", err)
+		return nil, ErrMiss
+	}
+	t.backOK(ctx)
+	_ = t.front.Set(ctx, key, value, t.frontTTL)
+	return value, nil
+}
+
+func (t *Tiered) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+	values, _ := t.front.GetMany(ctx, keys)
+	var missing []string
+	var missingAt []int
+	for i, value := range values {
+		if value == nil {
+			missing = append(missing, keys[i])
+			missingAt = append(missingAt, i)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	backValues, err := t.back.GetMany(ctx, missing)
+	if err != nil {
+		t.backFailed(ctx, "redis cache read failed, serving from memory only", err)
+		return values, nil
+	}
+	t.backOK(ctx)
+	for j, value := range backValues {
+		if value != nil {
+			values[missingAt[j]] = value
+			_ = t.front.Set(ctx, missing[j], value, t.frontTTL)
+		}
+	}
+	return values, nil
+}
+
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_ = t.front.Set(ctx, key, value, t.frontTTLFor(ttl))
+	if err := t.back.Set(ctx, key, value, ttl); err != nil {
+		t.backFailed(ctx, "redis cache write failed, keeping entries in memory only", err)
+		return nil
+	}
+	t.backOK(ctx)
+	return nil
+}
+
+func (t *Tiered) Delete(ctx context.Context, keys ...string) (int64, error) {
+	frontDeleted, _ := t.front.Delete(ctx, keys...)
+	backDeleted, err := t.back.Delete(ctx, keys...)
+	if err != nil {
+		return frontDeleted, err
+	}
+	return max(frontDeleted, backDeleted), nil
+}
+
+// Keys lists Redis, which holds everything the memory tier does for longer
+func (t *Tiered) Keys(ctx context.Context, pattern string) ([]string, error) {
+	keys, err := t.back.Keys(ctx, pattern)
+	if err != nil {
+		t.backFailed(ctx, "redis cache scan failed, listing memory only", err)
+		return t.front.Keys(ctx, pattern)
+	}
+	return keys, nil
+}
+
+func (t *Tiered) Stat(ctx context.Context, keys []string) ([]EntryInfo, error) {
+	infos, err := t.back.Stat(ctx, keys)
+	if err != nil {
+		t.backFailed(ctx, "redis cache stat failed, describing memory only", err)
+		return t.front.Stat(ctx, keys)
+	}
+	return infos, nil
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"app/cache"
+	"app/logging"
+	"app/tracing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is loaded once at startup. Values come from, in increasing priority: the defaults
+// below, the YAML file given by -config or CONFIG_FILE, environment variables, and flags.
+type Config struct {
+	Port        int      `yaml:"port"`
+	CORSOrigins []string `yaml:"cors_origins"`
+	AdminToken  string   `yaml:"admin_token"` // admin routes are disabled if empty
+
+	// Addresses or CIDR ranges of the reverse proxies or load balancers in front of the service.
+	// For requests from them the client IP is read from ProxyHeader, which they must set (not
+	// append to), otherwise clients can pick their own IP. Empty uses the connection's address.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	ProxyHeader    string   `yaml:"proxy_header"`
+
+	// How long shutdown waits for in-flight requests and background jobs
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	Log     LogConfig     `yaml:"log"`
+	Tracing TracingConfig `yaml:"tracing"`
+
+	Spotify       SpotifyConfig       `yaml:"spotify"`
+	Redis         RedisConfig         `yaml:"redis"`
+	Cache         CacheConfig         `yaml:"cache"`
+	Subscriptions SubscriptionsConfig `yaml:"subscriptions"`
+	Webhooks      WebhooksConfig      `yaml:"webhooks"`
+	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // json or text
+}
+
+type TracingConfig struct {
+	Exporter     string `yaml:"exporter"`      // none, stdout or otlp
+	OTLPEndpoint string `yaml:"otlp_endpoint"` // e.g. http://collector:4318; empty uses OTEL_EXPORTER_OTLP_ENDPOINT
+}
+
+type SpotifyConfig struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RedirectURI  string `yaml:"redirect_uri"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+type CacheConfig struct {
+	Backend     string        `yaml:"backend"`
+	MemoryMaxMB int           `yaml:"memory_max_mb"`
+	FrontTTL    time.Duration `yaml:"front_ttl"` // tiered backend only
+
+	ArtistSoftTTL   time.Duration `yaml:"artist_soft_ttl"`
+	ArtistHardTTL   time.Duration `yaml:"artist_hard_ttl"`
+	ArtistAlbumsTTL time.Duration `yaml:"artist_albums_ttl"`
+	AlbumTTL        time.Duration `yaml:"album_ttl"`
+}
+
+type SubscriptionsConfig struct {
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+type WebhooksConfig struct {
+	// AllowPrivateAddresses lets webhooks target loopback and private addresses, e.g. a test
+	// receiver on localhost or another compose service. Off by default, since it lets users
+	// make the service send requests into its own network.
+	AllowPrivateAddresses bool `yaml:"allow_private_addresses"`
+}
+
+// RateLimitConfig limits how often clients can call the API. Counters live in Redis, so the
+// limits hold across replicas; if Redis is unreachable requests are let through.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PerIP applies to every request from a client IP, before authentication
+	PerIP RateLimit `yaml:"per_ip"`
+	// Routes adds limits to single routes, keyed by method and pattern, e.g. "POST /playlist/create".
+	// Entries in the config file are merged into the defaults.
+	Routes map[string]RouteRateLimit `yaml:"routes"`
+}
+
+type RouteRateLimit struct {
+	PerUser RateLimit `yaml:"per_user"`
+	PerIP   RateLimit `yaml:"per_ip"`
+}
+
+// RateLimit allows Requests requests per Window. Zero requests means no limit.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Window   time.Duration `yaml:"window"`
+}
+
+func defaults() *Config {
+	return &Config{
+		Port:            8080,
+		CORSOrigins:     []string{"http://localhost:3000", "http://127.0.0.1:3000"},
+		ProxyHeader:     "X-Forwarded-For",
+		ShutdownTimeout: 30 * time.Second,
+		Log:             LogConfig{Level: "info", Format: logging.FormatJSON},
+		Tracing:         TracingConfig{Exporter: tracing.ExporterNone},
+		Redis:           RedisConfig{Addr: "localhost:6379"},
+		Cache: CacheConfig{
+			Backend:         cache.BackendRedis,
+			MemoryMaxMB:     256,
+			FrontTTL:        5 * time.Minute,
+			ArtistSoftTTL:   6 * time.Hour,
+			ArtistHardTTL:   7 * 24 * time.Hour,
+			ArtistAlbumsTTL: 6 * time.Hour,
+			AlbumTTL:        30 * 24 * time.Hour,
+		},
+		Subscriptions: SubscriptionsConfig{CheckInterval: time.Hour},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			PerIP:   RateLimit{Requests: 300, Window: time.Minute},
+			// Building a playlist crawls whole discographies and creates a new playlist
+			Routes: map[string]RouteRateLimit{
+				"POST /login":                {PerIP: RateLimit{Requests: 20, Window: time.Minute}},
+				"POST /playlist/create":      {PerUser: RateLimit{Requests: 10, Window: time.Hour}, PerIP: RateLimit{Requests: 30, Window: time.Hour}},
+				"POST /playlist/modify":      {PerUser: RateLimit{Requests: 30, Window: time.Hour}, PerIP: RateLimit{Requests: 90, Window: time.Hour}},
+				"POST /playlist/:id/restore": {PerUser: RateLimit{Requests: 30, Window: time.Hour}},
+				"POST /webhooks/:id/ping":    {PerUser: RateLimit{Requests: 10, Window: time.Minute}},
+				"GET /artists/:id/releases":  {PerUser: RateLimit{Requests: 60, Window: time.Minute}},
+			},
+		},
+	}
+}
+
+// Load builds the configuration from args (usually os.Args[1:]) and the environment, and
+// reports every problem it finds at once
+func Load(args []string) (*Config, error) {
+	cfg := defaults()
+
+	flags := flag.NewFlagSet("playmaker", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	port := flags.Int("port", 0, "port to listen on")
+	redisAddr := flags.String("redis-addr", "", "Redis address")
+	cacheBackend := flags.String("cache-backend", "", "cache backend: redis, memory or tiered")
+	logLevel := flags.String("log-level", "", "log level: debug, info, warn or error")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("parse config file %s: %w", *configFile, err)
+		}
+	}
+
+	env := &envLoader{}
+	env.int("PORT", &cfg.Port)
+	env.list("CORS_ORIGINS", &cfg.CORSOrigins)
+	env.string("ADMIN_TOKEN", &cfg.AdminToken)
+	env.list("TRUSTED_PROXIES", &cfg.TrustedProxies)
+	env.string("PROXY_HEADER", &cfg.ProxyHeader)
+	env.duration("SHUTDOWN_TIMEOUT_SECONDS", time.Second, &cfg.ShutdownTimeout)
+	env.string("LOG_LEVEL", &cfg.Log.Level)
+	env.string("LOG_FORMAT", &cfg.Log.Format)
+	env.string("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	env.string("TRACING_OTLP_ENDPOINT", &cfg.Tracing.OTLPEndpoint)
+	env.string("SPOTIFY_CLIENT_ID", &cfg.Spotify.ClientID)
+	env.string("SPOTIFY_CLIENT_SECRET", &cfg.Spotify.ClientSecret)
+	env.string("SPOTIFY_REDIRECT_URI", &cfg.Spotify.RedirectURI)
+	env.string("REDIS_ADDR", &cfg.Redis.Addr)
+	env.string("REDIS_PASSWORD", &cfg.Redis.Password)
+	env.int("REDIS_DB", &cfg.Redis.DB)
+	env.string("CACHE_BACKEND", &cfg.Cache.Backend)
+	env.int("CACHE_MEMORY_MAX_MB", &cfg.Cache.MemoryMaxMB)
+	env.duration("CACHE_TIERED_FRONT_TTL_SECONDS", time.Second, &cfg.Cache.FrontTTL)
+	env.duration("ARTIST_CACHE_SOFT_TTL_MINUTES", time.Minute, &cfg.Cache.ArtistSoftTTL)
+	env.duration("ARTIST_CACHE_HARD_TTL_MINUTES", time.Minute, &cfg.Cache.ArtistHardTTL)
+	env.duration("ARTIST_ALBUMS_CACHE_TTL_MINUTES", time.Minute, &cfg.Cache.ArtistAlbumsTTL)
+	env.duration("ALBUM_CACHE_TTL_MINUTES", time.Minute, &cfg.Cache.AlbumTTL)
+	env.duration("SUBSCRIPTION_CHECK_INTERVAL_MINUTES", time.Minute, &cfg.Subscriptions.CheckInterval)
+	env.bool("WEBHOOKS_ALLOW_PRIVATE_ADDRESSES", &cfg.Webhooks.AllowPrivateAddresses)
+	env.bool("RATE_LIMIT_ENABLED", &cfg.RateLimit.Enabled)
+	env.int("RATE_LIMIT_PER_IP", &cfg.RateLimit.PerIP.Requests)
+
+	if *port != 0 {
+		cfg.Port = *port
+	}
+	if *redisAddr != "" {
+		cfg.Redis.Addr = *redisAddr
+	}
+	if *cacheBackend != "" {
+		cfg.Cache.Backend = *cacheBackend
+	}
+	if *logLevel != "" {
+		cfg.Log.Level = *logLevel
+	}
+
+	if err := errors.Join(append(env.errs, cfg.validate()...)...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() []error {
+	var errs []error
+	required := func(name, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+	positive := func(name string, value time.Duration) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", name, value))
+		}
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", cfg.Port))
+	}
+	positive("shutdown_timeout", cfg.ShutdownTimeout)
+	// The router allows credentials, which browsers never send to a wildcard origin
+	if slices.Contains(cfg.CORSOrigins, "*") {
+		errs = append(errs, errors.New(`cors_origins can't contain "*" as credentials are allowed, list the origins instead`))
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("trusted_proxies: %q is not an IP address or CIDR range", proxy))
+			}
+		}
+	}
+	if len(cfg.TrustedProxies) > 0 && cfg.ProxyHeader == "" {
+		errs = append(errs, errors.New("proxy_header is required with trusted_proxies"))
+	}
+	if _, err := logging.ParseLevel(cfg.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	if cfg.Log.Format != logging.FormatJSON && cfg.Log.Format != logging.FormatText {
+		errs = append(errs, fmt.Errorf("log.format must be %s or %s, got %q", logging.FormatJSON, logging.FormatText, cfg.Log.Format))
+	}
+	switch cfg.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be %s, %s or %s, got %q", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP, cfg.Tracing.Exporter))
+	}
+	required("spotify.client_id (SPOTIFY_CLIENT_ID)", cfg.Spotify.ClientID)
+	required("spotify.client_secret (SPOTIFY_CLIENT_SECRET)", cfg.Spotify.ClientSecret)
+	required("spotify.redirect_uri (SPOTIFY_REDIRECT_URI)", cfg.Spotify.RedirectURI)
+	required("redis.addr (REDIS_ADDR)", cfg.Redis.Addr)
+
+	switch cfg.Cache.Backend {
+	case cache.BackendRedis, cache.BackendMemory, cache.BackendTiered:
+	default:
+		errs = append(errs, fmt.Errorf("cache.backend must be %s, %s or %s, got %q", cache.BackendRedis, cache.BackendMemory, cache.BackendTiered, cfg.Cache.Backend))
+	}
+	if cfg.Cache.MemoryMaxMB <= 0 {
+		errs = append(errs, fmt.Errorf("cache.memory_max_mb must be positive, got %d", cfg.Cache.MemoryMaxMB))
+	}
+	positive("cache.front_ttl", cfg.Cache.FrontTTL)
+	positive("cache.artist_soft_ttl", cfg.Cache.ArtistSoftTTL)
+	positive("cache.artist_hard_ttl", cfg.Cache.ArtistHardTTL)
+	positive("cache.artist_albums_ttl", cfg.Cache.ArtistAlbumsTTL)
+	positive("cache.album_ttl", cfg.Cache.AlbumTTL)
+	if cfg.Cache.ArtistSoftTTL > cfg.Cache.ArtistHardTTL {
+		errs = append(errs, fmt.Errorf("cache.artist_soft_ttl (%s) must not exceed cache.artist_hard_ttl (%s)", cfg.Cache.ArtistSoftTTL, cfg.Cache.ArtistHardTTL))
+	}
+	positive("subscriptions.check_interval", cfg.Subscriptions.CheckInterval)
+
+	rateLimit := func(name string, limit RateLimit) {
+		if limit.Requests < 0 {
+			errs = append(errs, fmt.Errorf("%s.requests must not be negative, got %d", name, limit.Requests))
+		}
+		if limit.Requests > 0 {
+			positive(name+".window", limit.Window)
+		}
+	}
+	rateLimit("rate_limit.per_ip", cfg.RateLimit.PerIP)
+	for _, route := range slices.Sorted(maps.Keys(cfg.RateLimit.Routes)) {
+		limits := cfg.RateLimit.Routes[route]
+		if method, path, ok := strings.Cut(route, " "); !ok || method == "" || !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("rate_limit.routes: %q must be a method and a path, e.g. \"POST /playlist/create\"", route))
+		}
+		rateLimit(fmt.Sprintf("rate_limit.routes[%q].per_user", route), limits.PerUser)
+		rateLimit(fmt.Sprintf("rate_limit.routes[%q].per_ip", route), limits.PerIP)
+	}
+	return errs
+}
+
+// CacheOptions returns the options for cache.New
+func (cfg *Config) CacheOptions() cache.Options {
+	return cache.Options{
+		Backend:        cfg.Cache.Backend,
+		MemoryMaxBytes: int64(cfg.Cache.MemoryMaxMB) * 1024 * 1024,
+		FrontTTL:       cfg.Cache.FrontTTL,
+	}
+}
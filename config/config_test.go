@@ -0,0 +1,226 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadEnvKeys are the variables Load reads, cleared so the test environment can't leak in
+var loadEnvKeys = []string{
+	"CONFIG_FILE", "PORT", "CORS_ORIGINS", "ADMIN_TOKEN", "TRUSTED_PROXIES", "PROXY_HEADER",
+	"SHUTDOWN_TIMEOUT_SECONDS", "LOG_LEVEL", "LOG_FORMAT", "TRACING_EXPORTER", "TRACING_OTLP_ENDPOINT",
+	"SPOTIFY_CLIENT_ID", "SPOTIFY_CLIENT_SECRET", "SPOTIFY_REDIRECT_URI",
+	"REDIS_ADDR", "REDIS_PASSWORD", "REDIS_DB", "CACHE_BACKEND", "CACHE_MEMORY_MAX_MB",
+	"CACHE_TIERED_FRONT_TTL_SECONDS", "ARTIST_CACHE_SOFT_TTL_MINUTES", "ARTIST_CACHE_HARD_TTL_MINUTES",
+	"ARTIST_ALBUMS_CACHE_TTL_MINUTES", "ALBUM_CACHE_TTL_MINUTES", "SUBSCRIPTION_CHECK_INTERVAL_MINUTES",
+	"WEBHOOKS_ALLOW_PRIVATE_ADDRESSES", "RATE_LIMIT_ENABLED", "RATE_LIMIT_PER_IP",
+}
+
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, key := range loadEnvKeys {
+		t.Setenv(key, "") // restored after the test
+		os.Unsetenv(key)
+	}
+	env = mergeEnv(map[string]string{
+		"SPOTIFY_CLIENT_ID":     "id",
+		"SPOTIFY_CLIENT_SECRET": "secret",
+		"SPOTIFY_REDIRECT_URI":  "http://localhost/callback",
+	}, env)
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+// mergeEnv returns base overridden by env; "-" unsets a key
+func mergeEnv(base, env map[string]string) map[string]string {
+	for key, value := range env {
+		if value == "-" {
+			delete(base, key)
+		} else {
+			base[key] = value
+		}
+	}
+	return base
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		file    string
+		args    []string
+		wantErr []string // substrings, all of which must be in the error
+		check   func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Port != 8080 || cfg.Cache.Backend != "redis" || cfg.Log.Level != "info" {
+					t.Errorf("unexpected defaults %+v", cfg)
+				}
+				if !cfg.RateLimit.Enabled || cfg.RateLimit.PerIP.Requests != 300 {
+					t.Errorf("unexpected rate limit defaults %+v", cfg.RateLimit)
+				}
+				if cfg.Webhooks.AllowPrivateAddresses {
+					t.Error("webhooks to private addresses should be off by default")
+				}
+			},
+		},
+		{
+			name: "environment",
+			env: map[string]string{
+				"PORT":                             "9000",
+				"CORS_ORIGINS":                     "https://a.example, https://b.example",
+				"TRUSTED_PROXIES":                  "10.0.0.0/8",
+				"ARTIST_CACHE_SOFT_TTL_MINUTES":    "30",
+				"RATE_LIMIT_ENABLED":               "false",
+				"WEBHOOKS_ALLOW_PRIVATE_ADDRESSES": "true",
+			},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Port != 9000 {
+					t.Errorf("port = %d, want 9000", cfg.Port)
+				}
+				if want := []string{"https://a.example", "https://b.example"}; !slices.Equal(cfg.CORSOrigins, want) {
+					t.Errorf("cors origins = %v, want %v", cfg.CORSOrigins, want)
+				}
+				if !slices.Equal(cfg.TrustedProxies, []string{"10.0.0.0/8"}) {
+					t.Errorf("trusted proxies = %v", cfg.TrustedProxies)
+				}
+				if cfg.Cache.ArtistSoftTTL != 30*time.Minute {
+					t.Errorf("artist soft ttl = %s, want 30m", cfg.Cache.ArtistSoftTTL)
+				}
+				if cfg.RateLimit.Enabled {
+					t.Error("rate limit should be disabled")
+				}
+				if !cfg.Webhooks.AllowPrivateAddresses {
+					t.Error("webhooks should allow private addresses")
+				}
+			},
+		},
+		{
+			name: "file, then environment, then flags",
+			env:  map[string]string{"PORT": "9000", "LOG_LEVEL": "warn"},
+			file: `
+port: 7000
+log:
+  level: debug
+  format: text
+cache:
+  artist_soft_ttl: 2h
+rate_limit:
+  routes:
+    "POST /playlist/create":
+      per_user: {requests: 1, window: 1m}
+`,
+			args: []string{"-log-level", "error"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Port != 9000 || cfg.Log.Level != "error" || cfg.Log.Format != "text" {
+					t.Errorf("port = %d, log = %+v, want 9000, error, text", cfg.Port, cfg.Log)
+				}
+				if cfg.Cache.ArtistSoftTTL != 2*time.Hour {
+					t.Errorf("artist soft ttl = %s, want 2h", cfg.Cache.ArtistSoftTTL)
+				}
+				routes := cfg.RateLimit.Routes
+				if got := routes["POST /playlist/create"].PerUser; got.Requests != 1 || got.Window != time.Minute {
+					t.Errorf("create limit = %+v, want 1 per minute", got)
+				}
+				if _, found := routes["POST /login"]; !found {
+					t.Error("routes from the file should be merged into the defaults")
+				}
+			},
+		},
+		{
+			name:    "missing Spotify credentials",
+			env:     map[string]string{"SPOTIFY_CLIENT_ID": "-", "SPOTIFY_CLIENT_SECRET": "-"},
+			wantErr: []string{"spotify.client_id", "spotify.client_secret"},
+		},
+		{
+			name:    "every problem at once",
+			env:     map[string]string{"PORT": "http", "RATE_LIMIT_ENABLED": "sometimes", "CACHE_BACKEND": "disk"},
+			wantErr: []string{"PORT", "RATE_LIMIT_ENABLED", "cache.backend"},
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-verbose"},
+			wantErr: []string{"verbose"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				env = mergeEnv(map[string]string{"CONFIG_FILE": path}, env)
+			}
+			setEnv(t, env)
+
+			cfg, err := Load(tt.args)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("expected an error mentioning %v", tt.wantErr)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q doesn't mention %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(cfg *Config)
+		wantErr string // empty if valid
+	}{
+		{"valid", func(cfg *Config) {}, ""},
+		{"port", func(cfg *Config) { cfg.Port = 70000 }, "port must be between"},
+		{"wildcard CORS origin", func(cfg *Config) { cfg.CORSOrigins = []string{"https://a.example", "*"} }, "cors_origins"},
+		{"trusted proxy address", func(cfg *Config) { cfg.TrustedProxies = []string{"10.0.0.1", "fd00::/8"} }, ""},
+		{"trusted proxy hostname", func(cfg *Config) { cfg.TrustedProxies = []string{"proxy.internal"} }, "trusted_proxies"},
+		{"proxy header", func(cfg *Config) { cfg.TrustedProxies = []string{"10.0.0.1"}; cfg.ProxyHeader = "" }, "proxy_header"},
+		{"log level", func(cfg *Config) { cfg.Log.Level = "loud" }, "log.level"},
+		{"tracing exporter", func(cfg *Config) { cfg.Tracing.Exporter = "jaeger" }, "tracing.exporter"},
+		{"soft ttl above hard ttl", func(cfg *Config) { cfg.Cache.ArtistSoftTTL = 2 * cfg.Cache.ArtistHardTTL }, "artist_soft_ttl"},
+		{"zero duration", func(cfg *Config) { cfg.Subscriptions.CheckInterval = 0 }, "subscriptions.check_interval"},
+		{"negative rate limit", func(cfg *Config) { cfg.RateLimit.PerIP.Requests = -1 }, "rate_limit.per_ip.requests"},
+		{"rate limit without window", func(cfg *Config) { cfg.RateLimit.PerIP.Window = 0 }, "rate_limit.per_ip.window"},
+		{"no rate limit needs no window", func(cfg *Config) { cfg.RateLimit.PerIP = RateLimit{} }, ""},
+		{"rate limit route", func(cfg *Config) {
+			cfg.RateLimit.Routes["/playlist/create"] = RouteRateLimit{}
+		}, "must be a method and a path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaults()
+			cfg.Spotify = SpotifyConfig{ClientID: "id", ClientSecret: "secret", RedirectURI: "http://localhost/callback"}
+			tt.modify(cfg)
+
+			errs := cfg.validate()
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Errorf("unexpected errors %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Errorf("errors = %v, want one mentioning %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
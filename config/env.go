@@ -1,50 +1,75 @@
 package config
 
 import (
-	"log"
+	"fmt"
 	"os"
 	"strconv"
-
-	// utils "github.com/ItsMeSamey/go_utils"
-	// "github.com/joho/godotenv"
+	"strings"
+	"time"
 )
 
-var IsDebug bool
+// envLoader overrides config values with the environment variables that are set, collecting
+// parse errors instead of stopping at the first one
+type envLoader struct {
+	errs []error
+}
 
-// func loadEnv() {
-// 	err := godotenv.Load()
-// 	utils.WithStack(err)
-// 	if err != nil {
-// 		log.Fatal("Error loading .env file")
-// 	}
-// 	IsDebug = os.Getenv("DEBUG") == "true"
-// }
+func (l *envLoader) string(key string, target *string) {
+	if value, exists := os.LookupEnv(key); exists {
+		*target = value
+	}
+}
 
-func Getenv(key string) string {
+func (l *envLoader) int(key string, target *int) {
 	value, exists := os.LookupEnv(key)
 	if !exists {
-		log.Fatalf("Missing environment variable: %s", key)
+		return
 	}
-	return value
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid value for environment variable %s: %q is not a number", key, value))
+		return
+	}
+	*target = v
 }
 
-func GetEnvAsInt(key string, defaultValue int) (val int) {
+// duration reads a whole number of units, e.g. minutes for ARTIST_CACHE_SOFT_TTL_MINUTES
+func (l *envLoader) duration(key string, unit time.Duration, target *time.Duration) {
+	if _, exists := os.LookupEnv(key); !exists {
+		return
+	}
+	var v int
+	before := len(l.errs)
+	l.int(key, &v)
+	if len(l.errs) == before {
+		*target = time.Duration(v) * unit
+	}
+}
+
+// list reads a comma separated list
+func (l *envLoader) list(key string, target *[]string) {
 	value, exists := os.LookupEnv(key)
 	if !exists {
-		return defaultValue
+		return
 	}
-	strv, err := strconv.Atoi(value)
-	if err != nil {
-		log.Printf("Invalid value for environment variable %s: %s", key, value)
-		log.Fatalf("Error: %v", err)
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	return strv
+	*target = items
 }
 
-func GetEnvAsBool(key string, defaultValue bool) (val bool) {
+func (l *envLoader) bool(key string, target *bool) {
 	value, exists := os.LookupEnv(key)
 	if !exists {
-		return defaultValue
+		return
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid value for environment variable %s: %q is not a boolean", key, value))
+		return
 	}
-	return value == "true"
+	*target = v
 }
@@ -2,24 +2,37 @@ package config
 
 import (
 	"context"
-	"log"
+	"log/slog"
+
+	"app/cache"
+	"app/tracing"
 
-	utils "github.com/ItsMeSamey/go_utils"
 	"github.com/go-redis/redis/v8"
 )
 
-
-var RedisClient *redis.Client
-
-func init() {
-	RedisClient = redis.NewClient(&redis.Options{
-		Addr:     Getenv("REDIS_ADDR"),
+// Connect creates the Redis client and the cache for cfg. Nothing connects when the package is
+// imported, and the caller owns the client and closes it.
+func Connect(cfg *Config) (*redis.Client, cache.Cache, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
 	})
+	client.AddHook(tracing.RedisHook{})
+
+	// Test the connection. Without Redis the service still starts: cached data can live in
+	// memory, and features that store state in Redis fail until it is reachable.
+	ping, err := client.Ping(context.Background()).Result()
+	if err != nil {
+		slog.Warn("redis is not reachable, continuing without it", "addr", cfg.Redis.Addr, "err", err)
+	} else {
+		slog.Info("connected to redis", "addr", cfg.Redis.Addr, "ping", ping)
+	}
 
-	// Test the connection
-	ping, err := RedisClient.Ping(context.Background()).Result()
+	c, err := cache.New(cfg.CacheOptions(), cache.NewRedis(client))
 	if err != nil {
-		panic("Failed to connect to Redis: " + utils.WithStack(err).Error())
+		client.Close()
+		return nil, nil, err
 	}
-	log.Println("Connected to Redis:", ping)
+	return client, c, nil
 }
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"app/apierr"
+	"app/middleware"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const maxWarmArtists = 500
+
+// Patterns may only match cache keys, so invalidation can't wipe tokens, subscriptions or history
+var cacheKeyPrefixes = []string{"artist_tracks:", "cache:"}
+
+// CachedArtist describes one artist_tracks entry
+type CachedArtist struct {
+	ArtistID  string `json:"artist_id"`
+	SizeBytes int64  `json:"size_bytes"`
+	AgeSecs   *int64 `json:"age_seconds"` // null if the entry can't be read
+	TTLSecs   int64  `json:"ttl_seconds"`
+}
+
+type CacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	Corrupt int64   `json:"corrupt"` // included in Misses
+	HitRate float64 `json:"hit_rate"`
+}
+
+type WarmCacheRequest struct {
+	ArtistIDs []string `json:"artist_ids"`
+}
+
+// getAppAccessToken returns a client-credentials token, which can read catalog data without a user
+func (h *Handlers) getAppAccessToken(ctx context.Context) (string, error) {
+	h.appToken.mu.Lock()
+	defer h.appToken.mu.Unlock()
+	if h.appToken.token != "" && time.Now().Before(h.appToken.expires) {
+		return h.appToken.token, nil
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+	token, err := h.requestSpotifyToken(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	h.appToken.token = token.AccessToken
+	h.appToken.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return h.appToken.token, nil
+}
+
+func (h *Handlers) GetCachedArtists(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	keys, err := h.cache.Keys(ctx, artistTracksKey("*"))
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to list cached artists")
+	}
+
+	infos, err := h.cache.Stat(ctx, keys)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to inspect cached artists")
+	}
+	entries, err := h.cache.GetMany(ctx, keys)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to inspect cached artists")
+	}
+
+	artists := make([]CachedArtist, 0, len(keys))
+	for i, key := range keys {
+		if !infos[i].Exists {
+			// Expired since it was listed
+			continue
+		}
+		artist := CachedArtist{
+			ArtistID:  strings.TrimPrefix(key, artistTracksKey("")),
+			SizeBytes: infos[i].Size,
+			TTLSecs:   int64(infos[i].TTL.Seconds()),
+		}
+		if entries[i] != nil {
+			if fetchedAt, err := cacheEntryFetchedAt(entries[i]); err == nil {
+				age := int64(time.Since(fetchedAt).Seconds())
+				artist.AgeSecs = &age
+			}
+		}
+		artists = append(artists, artist)
+	}
+	return c.Status(fiber.StatusOK).JSON(artists)
+}
+
+func (h *Handlers) InvalidateArtistCache(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	artistID := c.Params("id")
+	h.clearArtistCache(ctx, artistID)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": fmt.Sprintf("Cache cleared for artist %s", artistID),
+	})
+}
+
+// InvalidateCache deletes every cache key matching the "pattern" query parameter
+func (h *Handlers) InvalidateCache(c fiber.Ctx) error {
+	pattern := c.Query("pattern")
+	allowed := false
+	for _, prefix := range cacheKeyPrefixes {
+		if strings.HasPrefix(pattern, prefix) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return apierr.New(apierr.CodeInvalidRequest, fmt.Sprintf("pattern must start with one of %s", strings.Join(cacheKeyPrefixes, ", ")))
+	}
+
+	ctx := middleware.Context(c)
+	keys, err := h.cache.Keys(ctx, pattern)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to list cache keys")
+	}
+	deleted, err := h.cache.Delete(ctx, keys...)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to delete cache keys")
+	}
+	slog.InfoContext(ctx, "cleared cache keys", "pattern", pattern, "deleted", deleted)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": fmt.Sprintf("Cleared %d keys", deleted),
+		"deleted": deleted,
+	})
+}
+
+// WarmCache crawls the given artists in the background with the app's own token
+func (h *Handlers) WarmCache(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	var req WarmCacheRequest
+	if err := c.Bind().Body(&req); err != nil || len(req.ArtistIDs) == 0 {
+		return apierr.New(apierr.CodeInvalidRequest, "artist_ids is required")
+	}
+	if len(req.ArtistIDs) > maxWarmArtists {
+		return apierr.New(apierr.CodeInvalidRequest, fmt.Sprintf("At most %d artists can be warmed at once", maxWarmArtists))
+	}
+	if _, err := h.getAppAccessToken(ctx); err != nil {
+		return apierr.Wrap(err, apierr.CodeSpotifyError, "Failed to get an app token")
+	}
+
+	h.jobs.run(ctx, jobCacheWarm, func(ctx context.Context) { h.warmArtists(ctx, req.ArtistIDs) })
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": fmt.Sprintf("Warming %d artists", len(req.ArtistIDs)),
+	})
+}
+
+// warmArtists crawls one artist at a time, to stay well inside Spotify's rate limits
+func (h *Handlers) warmArtists(ctx context.Context, artistIDs []string) {
+	warmed := 0
+	for i, artistID := range artistIDs {
+		if h.jobs.stopping() {
+			slog.InfoContext(ctx, "cache warming stopped for shutdown", "remaining", len(artistIDs)-i)
+			break
+		}
+		token, err := h.getAppAccessToken(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "cache warming stopped, no app token", "err", err)
+			return
+		}
+		if _, err := h.getCachedArtistTracks(ctx, artistID, token); err != nil {
+			slog.WarnContext(ctx, "could not warm artist cache", "artist_id", artistID, "err", err)
+			continue
+		}
+		warmed++
+	}
+	slog.InfoContext(ctx, "cache warming finished", "warmed", warmed, "requested", len(artistIDs))
+}
+
+// GetCacheStats returns the hit and miss counts of this process, per key type
+func (h *Handlers) GetCacheStats(c fiber.Ctx) error {
+	stats := make(map[string]CacheStats, len(cacheCounters))
+	for kind, counter := range cacheCounters {
+		s := CacheStats{Hits: counter.hits.Load(), Misses: counter.misses.Load(), Corrupt: counter.corrupt.Load()}
+		if total := s.Hits + s.Misses; total > 0 {
+			s.HitRate = float64(s.Hits) / float64(total)
+		}
+		stats[kind] = s
+	}
+	return c.Status(fiber.StatusOK).JSON(stats)
+}
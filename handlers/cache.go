@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"app/apierr"
+	"app/cache"
+	"app/metrics"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// A full crawl of a large discography takes a while; the lock expires on its own if the
+	// replica holding it dies mid-crawl
+	artistLockTTL  = 5 * time.Minute
+	artistLockWait = 3 * time.Minute
+	artistLockPoll = 500 * time.Millisecond
+)
+
+// Album metadata and contents rarely change once released, so they are kept much longer
+// (AlbumTTL) than the list of an artist's albums (ArtistAlbumsTTL), which changes with every
+// release. Keys are prefixed with albumCacheVersion; struct changes are handled by
+// cacheSchemaVersion instead.
+const albumCacheVersion = "v1"
+
+// Deletes the lock only if we still own it
+var releaseLockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// Key types, as reported by the cache stats
+const (
+	cacheKindArtistTracks = "artist_tracks"
+	cacheKindArtistAlbums = "artist_albums"
+	cacheKindAlbum        = "album"
+	cacheKindAlbumTracks  = "album_tracks"
+)
+
+type cacheCounter struct {
+	hits, misses, corrupt atomic.Int64
+}
+
+// cacheCounters counts lookups in this process since it started
+var cacheCounters = map[string]*cacheCounter{
+	cacheKindArtistTracks: {},
+	cacheKindArtistAlbums: {},
+	cacheKindAlbum:        {},
+	cacheKindAlbumTracks:  {},
+}
+
+func recordCacheLookup(kind string, hit bool) {
+	if hit {
+		cacheCounters[kind].hits.Add(1)
+		metrics.CacheLookups.WithLabelValues(kind, "hit").Inc()
+	} else {
+		cacheCounters[kind].misses.Add(1)
+		metrics.CacheLookups.WithLabelValues(kind, "miss").Inc()
+	}
+}
+
+// recordCacheCorrupt counts entries that failed to decode, on top of the miss they cause
+func recordCacheCorrupt(kind string) {
+	cacheCounters[kind].corrupt.Add(1)
+	metrics.CacheCorrupt.WithLabelValues(kind).Inc()
+}
+
+type cachedArtistTracks struct {
+	FetchedAt time.Time
+	Tracks    []SimplifiedTrack
+}
+
+func artistTracksKey(artistID string) string {
+	return fmt.Sprintf("artist_tracks:%s", artistID)
+}
+
+func artistAlbumsKey(artistID string) string {
+	return fmt.Sprintf("cache:%s:artist_albums:%s", albumCacheVersion, artistID)
+}
+
+func albumKey(albumID string) string {
+	return fmt.Sprintf("cache:%s:album:%s", albumCacheVersion, albumID)
+}
+
+func albumTracksKey(albumID string) string {
+	return fmt.Sprintf("cache:%s:album_tracks:%s", albumCacheVersion, albumID)
+}
+
+func artistLockKey(artistID string) string {
+	return fmt.Sprintf("lock:artist_tracks:%s", artistID)
+}
+
+// Caches under key "artist_tracks:{artistID}". Past the soft TTL cached tracks are still served,
+// but refreshed in the background. They are dropped at the hard TTL, after which requests wait
+// for a full crawl.
+func (h *Handlers) getCachedArtistTracks(ctx context.Context, artistID, token string) ([]SimplifiedTrack, error) {
+	// 1. Try to read from cache.
+	cached := h.readCachedArtistTracks(ctx, artistID)
+	recordCacheLookup(cacheKindArtistTracks, cached != nil)
+	if cached != nil {
+		age := time.Since(cached.FetchedAt)
+		if age > h.cfg.Cache.ArtistSoftTTL {
+			slog.DebugContext(ctx, "artist cache is stale, refreshing in background", "artist_id", artistID, "age", age.Round(time.Second))
+			h.refreshArtistTracksInBackground(ctx, artistID, token)
+		} else {
+			slog.DebugContext(ctx, "artist cache hit", "artist_id", artistID, "tracks", len(cached.Tracks))
+		}
+		return cached.Tracks, nil
+	}
+
+	// 2. Cache miss or decode problem: Fetch from Spotify and cache result. Concurrent
+	// requests for the same artist share a single crawl.
+	slog.DebugContext(ctx, "artist cache miss, fetching tracks", "artist_id", artistID)
+	ran := false
+	tracks, err, shared := h.artistLoads.Do(artistID, func() (any, error) {
+		ran = true
+		return h.loadArtistTracksLocked(ctx, artistID, token)
+	})
+	if err != nil && !ran && isCallerScoped(err) && ctx.Err() == nil {
+		// The crawl ran with another request's token and context, whose failure says nothing
+		// about ours
+		slog.DebugContext(ctx, "shared artist crawl failed for another request, crawling with own token", "artist_id", artistID, "err", err)
+		return h.loadArtistTracksLocked(ctx, artistID, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if shared {
+		slog.DebugContext(ctx, "shared artist crawl with concurrent requests", "artist_id", artistID)
+	}
+	return tracks.([]SimplifiedTrack), nil
+}
+
+// isCallerScoped reports whether err is specific to the request that got it: its token was
+// rejected or rate limited, or it was cancelled
+func isCallerScoped(err error) bool {
+	return apierr.HasCode(err, apierr.CodeTokenExpired) ||
+		apierr.HasCode(err, apierr.CodeSpotifyRateLimited) ||
+		apierr.HasCode(err, apierr.CodeForbidden) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// readCachedArtistTracks returns nil on a miss, or an outdated or corrupt entry
+func (h *Handlers) readCachedArtistTracks(ctx context.Context, artistID string) *cachedArtistTracks {
+	entry, err := h.cache.Get(ctx, artistTracksKey(artistID))
+	if err != nil {
+		if err != cache.ErrMiss {
+			slog.WarnContext(ctx, "could not read artist cache", "artist_id", artistID, "err", err)
+		}
+		return nil
+	}
+	var cached cachedArtistTracks
+	cached.FetchedAt, err = decodeCacheEntry(entry, cacheSource(), &cached.Tracks)
+	if err == errCacheOutdated {
+		slog.DebugContext(ctx, "artist cache is outdated, refetching", "artist_id", artistID)
+		return nil
+	}
+	if err != nil {
+		// If decode error, the caller refills the cache.
+		recordCacheCorrupt(cacheKindArtistTracks)
+		slog.WarnContext(ctx, "artist cache is corrupt, refetching", "artist_id", artistID, "err", err)
+		return nil
+	}
+	return &cached
+}
+
+// loadArtistTracksLocked crawls the artist while holding a Redis lock, so only one replica
+// crawls at a time. Other replicas wait for the cache to be filled instead, and crawl
+// themselves only if that takes longer than artistLockWait.
+func (h *Handlers) loadArtistTracksLocked(ctx context.Context, artistID, token string) ([]SimplifiedTrack, error) {
+	deadline := time.Now().Add(artistLockWait)
+	for {
+		unlock, acquired, err := h.acquireArtistLock(ctx, artistID)
+		if err != nil {
+			slog.WarnContext(ctx, "could not take artist crawl lock, crawling anyway", "artist_id", artistID, "err", err)
+			return h.fetchAndCacheArtistTracks(ctx, artistID, token)
+		}
+		if acquired {
+			defer unlock()
+			// The replica that held the lock before may have just filled the cache
+			if cached := h.readCachedArtistTracks(ctx, artistID); cached != nil {
+				return cached.Tracks, nil
+			}
+			return h.fetchAndCacheArtistTracks(ctx, artistID, token)
+		}
+
+		if !sleepContext(ctx, artistLockPoll) {
+			return nil, ctx.Err()
+		}
+		if cached := h.readCachedArtistTracks(ctx, artistID); cached != nil {
+			return cached.Tracks, nil
+		}
+		if time.Now().After(deadline) {
+			slog.WarnContext(ctx, "gave up waiting for another artist crawl", "artist_id", artistID)
+			return h.fetchAndCacheArtistTracks(ctx, artistID, token)
+		}
+	}
+}
+
+func (h *Handlers) acquireArtistLock(ctx context.Context, artistID string) (unlock func(), acquired bool, err error) {
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, false, err
+	}
+	value := hex.EncodeToString(owner)
+	key := artistLockKey(artistID)
+	acquired, err = h.redis.SetNX(ctx, key, value, artistLockTTL).Result()
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+	return func() {
+		if err := releaseLockScript.Run(ctx, h.redis, []string{key}, value).Err(); err != nil {
+			slog.WarnContext(ctx, "could not release artist crawl lock", "artist_id", artistID, "err", err)
+		}
+	}, true, nil
+}
+
+func (h *Handlers) fetchAndCacheArtistTracks(ctx context.Context, artistID, token string) ([]SimplifiedTrack, error) {
+	tracks, err := h.getAllArtistTracksWithAlbumID(ctx, artistID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := encodeCacheEntry(tracks, cacheSource())
+	if err == nil {
+		if err := h.cache.Set(ctx, artistTracksKey(artistID), data, h.cfg.Cache.ArtistHardTTL); err != nil {
+			slog.WarnContext(ctx, "could not cache artist tracks", "artist_id", artistID, "err", err)
+			return tracks, nil
+		}
+		slog.DebugContext(ctx, "cached artist tracks", "artist_id", artistID, "tracks", len(tracks))
+	}
+	return tracks, nil
+}
+
+// refreshArtistTracksInBackground recrawls the artist unless a refresh is already running
+func (h *Handlers) refreshArtistTracksInBackground(ctx context.Context, artistID, token string) {
+	if _, running := h.refreshingArtists.LoadOrStore(artistID, struct{}{}); running {
+		return
+	}
+	h.jobs.run(ctx, jobArtistRefresh, func(ctx context.Context) {
+		defer h.refreshingArtists.Delete(artistID)
+		unlock, acquired, err := h.acquireArtistLock(ctx, artistID)
+		if err == nil && !acquired {
+			// Another replica is already on it
+			return
+		}
+		if acquired {
+			defer unlock()
+		}
+		if _, err := h.fetchAndCacheArtistTracks(ctx, artistID, token); err != nil {
+			slog.WarnContext(ctx, "background artist refresh failed", "artist_id", artistID, "err", err)
+		}
+	})
+}
+
+// clearArtistCache drops the artist's tracks and album list. Album metadata and contents stay
+// cached, so the next crawl only fetches albums it hasn't seen.
+func (h *Handlers) clearArtistCache(ctx context.Context, artistID string) {
+	if _, err := h.cache.Delete(ctx, artistTracksKey(artistID), artistAlbumsKey(artistID)); err != nil {
+		slog.WarnContext(ctx, "could not clear artist cache", "artist_id", artistID, "err", err)
+		return
+	}
+	slog.InfoContext(ctx, "cleared artist cache", "artist_id", artistID)
+}
+
+/* ------------------ Albums ------------------ */
+
+// getCachedArtistAlbums returns the artist's albums with their metadata. The album list and
+// each album are cached separately, so albums shared between listings are stored once.
+func (h *Handlers) getCachedArtistAlbums(ctx context.Context, artistID, token string) ([]SimplifiedAlbum, error) {
+	if entry, err := h.cache.Get(ctx, artistAlbumsKey(artistID)); err == nil {
+		var albumIDs []string
+		_, err := decodeCacheEntry(entry, cacheSource(), &albumIDs)
+		if err == nil {
+			if albums, ok := h.getCachedAlbums(ctx, albumIDs); ok {
+				recordCacheLookup(cacheKindArtistAlbums, true)
+				return albums, nil
+			}
+		} else if err != errCacheOutdated {
+			recordCacheCorrupt(cacheKindArtistAlbums)
+		}
+	}
+	recordCacheLookup(cacheKindArtistAlbums, false)
+
+	albums, err := h.getArtistAlbums(ctx, artistID, token)
+	if err != nil {
+		return nil, err
+	}
+	albumIDs := make([]string, 0, len(albums))
+	for _, album := range albums {
+		albumIDs = append(albumIDs, album.ID)
+		if data, err := encodeCacheEntry(album, nil); err == nil {
+			if err := h.cache.Set(ctx, albumKey(album.ID), data, h.cfg.Cache.AlbumTTL); err != nil {
+				slog.WarnContext(ctx, "could not cache artist albums", "artist_id", artistID, "err", err)
+				return albums, nil
+			}
+		}
+	}
+	// The list goes last, so it is never cached without its albums
+	if data, err := encodeCacheEntry(albumIDs, cacheSource()); err == nil {
+		if err := h.cache.Set(ctx, artistAlbumsKey(artistID), data, h.cfg.Cache.ArtistAlbumsTTL); err != nil {
+			slog.WarnContext(ctx, "could not cache artist albums", "artist_id", artistID, "err", err)
+		}
+	}
+	return albums, nil
+}
+
+// getCachedAlbums returns false unless every album is cached
+func (h *Handlers) getCachedAlbums(ctx context.Context, albumIDs []string) ([]SimplifiedAlbum, bool) {
+	if len(albumIDs) == 0 {
+		return nil, true
+	}
+	keys := make([]string, 0, len(albumIDs))
+	for _, id := range albumIDs {
+		keys = append(keys, albumKey(id))
+	}
+	values, err := h.cache.GetMany(ctx, keys)
+	if err != nil {
+		return nil, false
+	}
+	albums := make([]SimplifiedAlbum, 0, len(values))
+	for _, value := range values {
+		recordCacheLookup(cacheKindAlbum, value != nil)
+		if value == nil {
+			return nil, false
+		}
+		var album SimplifiedAlbum
+		if _, err := decodeCacheEntry(value, nil, &album); err != nil {
+			if err != errCacheOutdated {
+				recordCacheCorrupt(cacheKindAlbum)
+			}
+			return nil, false
+		}
+		albums = append(albums, album)
+	}
+	return albums, true
+}
+
+// getCachedAlbumTracks returns every track on the album, whoever the artist
+func (h *Handlers) getCachedAlbumTracks(ctx context.Context, albumID, token string) ([]SimplifiedTrack, error) {
+	if entry, err := h.cache.Get(ctx, albumTracksKey(albumID)); err == nil {
+		var tracks []SimplifiedTrack
+		_, err := decodeCacheEntry(entry, nil, &tracks)
+		if err == nil {
+			recordCacheLookup(cacheKindAlbumTracks, true)
+			return tracks, nil
+		}
+		if err != errCacheOutdated {
+			recordCacheCorrupt(cacheKindAlbumTracks)
+			slog.WarnContext(ctx, "album cache is corrupt, refetching", "album_id", albumID, "err", err)
+		}
+	}
+	recordCacheLookup(cacheKindAlbumTracks, false)
+
+	tracks, err := h.getAlbumTracks(ctx, albumID, token)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := encodeCacheEntry(tracks, nil); err == nil {
+		if err := h.cache.Set(ctx, albumTracksKey(albumID), data, h.cfg.Cache.AlbumTTL); err != nil {
+			slog.WarnContext(ctx, "could not cache album tracks", "album_id", albumID, "err", err)
+		}
+	}
+	return tracks, nil
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"app/apierr"
+	"app/cache"
+	"app/config"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestSharedArtistCrawlFailsForOneToken(t *testing.T) {
+	// Without Redis the crawl lock can't be taken and everyone crawls, which is fine here:
+	// coalescing happens in process
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := closed.Addr().String()
+	closed.Close()
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	defer client.Close()
+
+	cfg := &config.Config{Cache: config.CacheConfig{
+		ArtistSoftTTL: time.Hour, ArtistHardTTL: 2 * time.Hour, ArtistAlbumsTTL: time.Hour, AlbumTTL: time.Hour,
+	}}
+	entered, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	var limitedCalls atomic.Int32
+	h := New(cfg, client, cache.NewLRU(1<<20), &http.Client{Transport: spotifyStub{
+		"/v1/artists/artist/albums": func(r *http.Request) any {
+			if r.Header.Get("Authorization") == "Bearer limited" {
+				limitedCalls.Add(1)
+				once.Do(func() { close(entered) })
+				<-release
+				return stubStatus(http.StatusTooManyRequests)
+			}
+			return ArtistAlbumsResponse{Items: []SimplifiedAlbum{{ID: "album", ReleaseDate: "2020"}}}
+		},
+		"/v1/albums/album/tracks": func(*http.Request) any {
+			return AlbumTracksResponse{Items: []SimplifiedTrack{{ID: "track", Artists: []TrackArtist{{ID: "artist"}}}}}
+		},
+	}}, NewJobs())
+
+	var limitedErr, okErr error
+	var okTracks []SimplifiedTrack
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, limitedErr = h.getCachedArtistTracks(context.Background(), "artist", "limited")
+	}()
+	<-entered
+	go func() {
+		defer wg.Done()
+		okTracks, okErr = h.getCachedArtistTracks(context.Background(), "artist", "ok")
+	}()
+	// Let the second request join the crawl in flight
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if !apierr.HasCode(limitedErr, apierr.CodeSpotifyRateLimited) {
+		t.Errorf("rate limited request got %v, want spotify_rate_limited", limitedErr)
+	}
+	if got := limitedCalls.Load(); got != 1 {
+		t.Errorf("rate limited token was used for %d crawls, want 1", got)
+	}
+	if okErr != nil || len(okTracks) != 1 {
+		t.Errorf("other request got %v, %v, want its own crawl to succeed", okTracks, okErr)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+)
+
+// cacheSchemaVersion is stored in every cache entry. Bump it whenever a cached struct changes,
+// so entries written by older code are refetched instead of decoding with missing fields.
+const cacheSchemaVersion = 2
+
+var errCacheOutdated = errors.New("cache entry was written with a different schema or source")
+
+// cacheEnvelope wraps every cached value. It is stored gzipped, since a large discography is
+// several hundred kilobytes of repetitive JSON.
+type cacheEnvelope struct {
+	Version   int               `json:"version"`
+	FetchedAt time.Time         `json:"fetched_at"`
+	Source    map[string]string `json:"source,omitempty"` // options the data was fetched with
+	Data      json.RawMessage   `json:"data"`
+}
+
+// cacheSource returns the fetch options that cached artist data depends on. An entry fetched
+// with other options is treated as a miss.
+func cacheSource() map[string]string {
+	return map[string]string{"include_groups": artistAlbumGroups}
+}
+
+func encodeCacheEntry(value any, source map[string]string) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := json.Marshal(cacheEnvelope{
+		Version:   cacheSchemaVersion,
+		FetchedAt: time.Now().UTC(),
+		Source:    source,
+		Data:      data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(envelope); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCacheEntry decodes an entry into target and returns when it was fetched. Entries from
+// an older schema, or fetched with other source options, return errCacheOutdated.
+func decodeCacheEntry(entry []byte, source map[string]string, target any) (time.Time, error) {
+	envelope, err := readCacheEnvelope(entry)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if envelope.Version != cacheSchemaVersion || !maps.Equal(envelope.Source, source) {
+		return time.Time{}, errCacheOutdated
+	}
+	if err := json.Unmarshal(envelope.Data, target); err != nil {
+		return time.Time{}, err
+	}
+	return envelope.FetchedAt, nil
+}
+
+// cacheEntryFetchedAt returns when an entry was fetched, whatever schema or source it was
+// written with
+func cacheEntryFetchedAt(entry []byte) (time.Time, error) {
+	envelope, err := readCacheEnvelope(entry)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return envelope.FetchedAt, nil
+}
+
+func readCacheEnvelope(entry []byte) (*cacheEnvelope, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(entry))
+	if err != nil {
+		// Entries from before the envelope are plain JSON
+		return nil, errCacheOutdated
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress cache entry: %w", err)
+	}
+
+	var envelope cacheEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	return &envelope, nil
+}
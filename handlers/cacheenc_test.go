@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCacheEntryRoundTrip(t *testing.T) {
+	tracks := []SimplifiedTrack{{ID: "a", Name: "Song", AlbumID: "x"}, {ID: "b", Name: "Other", AlbumID: "y"}}
+	source := map[string]string{"include_groups": "album"}
+
+	before := time.Now().UTC()
+	entry, err := encodeCacheEntry(tracks, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []SimplifiedTrack
+	fetchedAt, err := decodeCacheEntry(entry, source, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(tracks) || got[0].ID != "a" || got[1].AlbumID != "y" {
+		t.Errorf("decoded %+v, want %+v", got, tracks)
+	}
+	if fetchedAt.Before(before.Add(-time.Second)) || fetchedAt.After(time.Now().Add(time.Second)) {
+		t.Errorf("fetched at %s, want about %s", fetchedAt, before)
+	}
+}
+
+func TestDecodeCacheEntry(t *testing.T) {
+	source := map[string]string{"include_groups": "album"}
+	current, err := encodeCacheEntry([]string{"a"}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherVersion, err := json.Marshal(cacheEnvelope{Version: cacheSchemaVersion - 1, Source: source, Data: json.RawMessage(`["a"]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		entry        []byte
+		source       map[string]string
+		wantOutdated bool
+		wantErr      bool
+	}{
+		{"current", current, source, false, false},
+		{"other source", current, map[string]string{"include_groups": "album,single"}, true, true},
+		{"no source", current, nil, true, true},
+		{"other schema version", gzipped(t, otherVersion), source, true, true},
+		{"plain JSON from before the envelope", []byte(`["a"]`), source, true, true},
+		{"corrupt envelope", gzipped(t, []byte(`{"version":`)), source, false, true},
+		{"truncated", current[:len(current)-8], source, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			_, err := decodeCacheEntry(tt.entry, tt.source, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeCacheEntry() error = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errCacheOutdated) != tt.wantOutdated {
+				t.Errorf("decodeCacheEntry() error = %v, want outdated %v", err, tt.wantOutdated)
+			}
+			if err == nil && !slices.Equal(got, []string{"a"}) {
+				t.Errorf("decoded %v, want [a]", got)
+			}
+		})
+	}
+}
+
+func TestCacheEntryFetchedAt(t *testing.T) {
+	fetchedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	otherVersion, err := json.Marshal(cacheEnvelope{Version: cacheSchemaVersion - 1, FetchedAt: fetchedAt, Data: json.RawMessage(`[]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The age of an entry is known whatever it was written with
+	got, err := cacheEntryFetchedAt(gzipped(t, otherVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(fetchedAt) {
+		t.Errorf("fetched at %s, want %s", got, fetchedAt)
+	}
+
+	if _, err := cacheEntryFetchedAt([]byte(`[]`)); err == nil {
+		t.Error("expected an error for an entry without envelope")
+	}
+}
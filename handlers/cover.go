@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"app/apierr"
+)
+
+const (
+	CoverSourceUpload  = "upload"
+	CoverSourceArtist  = "artist"
+	CoverSourceCollage = "collage"
+
+	// Spotify rejects cover images whose base64 encoding is larger than 256 KB
+	maxCoverSize      = 256 * 1024
+	maxCoverDimension = 640
+	collageTiles      = 2 // collage is collageTiles x collageTiles album covers
+	maxImageDownload  = 10 * 1024 * 1024
+	// Images are decoded into memory at 4 bytes per pixel, so a small compressed file declaring
+	// huge dimensions would allocate gigabytes. This allows 4096 x 4096.
+	maxImagePixels = 4096 * 4096
+)
+
+var errImageTooLarge = fmt.Errorf("image is larger than %d pixels", maxImagePixels)
+
+// decodeImage decodes a JPEG or PNG, checking its dimensions before decoding the pixels
+func decodeImage(raw []byte) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxImagePixels/cfg.Height {
+		return nil, errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	return img, err
+}
+
+type CoverOptions struct {
+	Source string `json:"source"` // "upload", "artist" or "collage"
+	Image  string `json:"image"`  // base64 encoded JPEG or PNG, for "upload"
+}
+
+// buildCoverImage returns the base64 encoded JPEG to use as playlist cover
+func (h *Handlers) buildCoverImage(ctx context.Context, opts *CoverOptions, artistID, token string) ([]byte, error) {
+	var img image.Image
+	var err error
+	switch opts.Source {
+	case CoverSourceUpload:
+		img, err = decodeUploadedImage(opts.Image)
+	case CoverSourceArtist:
+		img, err = h.getArtistImage(ctx, artistID, token)
+	case CoverSourceCollage:
+		img, err = h.buildAlbumCollage(ctx, artistID, token)
+	default:
+		return nil, apierr.New(apierr.CodeInvalidRequest, "invalid cover source")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encodeCover(img)
+}
+
+func decodeUploadedImage(data string) (image.Image, error) {
+	// Accept data URLs as produced by browsers ("data:image/jpeg;base64,...")
+	if i := strings.Index(data, ","); strings.HasPrefix(data, "data:") && i >= 0 {
+		data = data[i+1:]
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, apierr.New(apierr.CodeInvalidRequest, "cover image is not valid base64")
+	}
+	img, err := decodeImage(raw)
+	if errors.Is(err, errImageTooLarge) {
+		return nil, apierr.New(apierr.CodeInvalidRequest, "cover image must be at most 4096 x 4096 pixels")
+	}
+	if err != nil {
+		return nil, apierr.New(apierr.CodeInvalidRequest, "cover image must be a JPEG or PNG")
+	}
+	return img, nil
+}
+
+func (h *Handlers) getArtistImage(ctx context.Context, artistID, token string) (image.Image, error) {
+	artist, err := h.getArtist(ctx, artistID, token)
+	if err != nil {
+		return nil, err
+	}
+	if len(artist.Images) == 0 {
+		return nil, apierr.New(apierr.CodeNotFound, "artist has no profile image")
+	}
+	return h.downloadImage(ctx, largestImage(artist.Images).URL)
+}
+
+// buildAlbumCollage tiles the covers of the artist's most recent albums
+func (h *Handlers) buildAlbumCollage(ctx context.Context, artistID, token string) (image.Image, error) {
+	albums, err := h.getCachedArtistAlbums(ctx, artistID, token)
+	if err != nil {
+		return nil, err
+	}
+	sortAlbumsByReleaseDate(albums)
+
+	var covers []image.Image
+	for _, album := range albums {
+		if len(covers) == collageTiles*collageTiles {
+			break
+		}
+		if len(album.Images) == 0 {
+			continue
+		}
+		img, err := h.downloadImage(ctx, largestImage(album.Images).URL)
+		if err != nil {
+			continue
+		}
+		covers = append(covers, img)
+	}
+	if len(covers) == 0 {
+		return nil, apierr.New(apierr.CodeNotFound, "artist has no album covers")
+	}
+	// Not enough albums for a grid, use the latest cover on its own
+	if len(covers) < collageTiles*collageTiles {
+		return covers[0], nil
+	}
+
+	tile := maxCoverDimension / collageTiles
+	collage := image.NewRGBA(image.Rect(0, 0, tile*collageTiles, tile*collageTiles))
+	for i, cover := range covers {
+		x, y := (i%collageTiles)*tile, (i/collageTiles)*tile
+		draw.Draw(collage, image.Rect(x, y, x+tile, y+tile), resizeImage(cover, tile, tile), image.Point{}, draw.Src)
+	}
+	return collage, nil
+}
+
+func sortAlbumsByReleaseDate(albums []SimplifiedAlbum) {
+	sort.SliceStable(albums, func(i, j int) bool {
+		return albums[i].ReleaseDate > albums[j].ReleaseDate
+	})
+}
+
+func largestImage(images []Image) Image {
+	largest := images[0]
+	for _, img := range images[1:] {
+		if img.Width*img.Height > largest.Width*largest.Height {
+			largest = img
+		}
+	}
+	return largest
+}
+
+func (h *Handlers) downloadImage(ctx context.Context, url string) (image.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image download failed with status %d", resp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxImageDownload))
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(raw)
+}
+
+// encodeCover downscales and recompresses img until its base64 encoded JPEG fits Spotify's limit
+func encodeCover(img image.Image) ([]byte, error) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if width > maxCoverDimension || height > maxCoverDimension {
+		width, height = fitDimensions(width, height, maxCoverDimension)
+		img = resizeImage(img, width, height)
+	}
+
+	for {
+		for quality := 90; quality >= 40; quality -= 10 {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, err
+			}
+			if base64.StdEncoding.EncodedLen(buf.Len()) <= maxCoverSize {
+				encoded := make([]byte, base64.StdEncoding.EncodedLen(buf.Len()))
+				base64.StdEncoding.Encode(encoded, buf.Bytes())
+				return encoded, nil
+			}
+		}
+		if width < 64 || height < 64 {
+			break
+		}
+		width, height = width/2, height/2
+		img = resizeImage(img, width, height)
+	}
+	return nil, apierr.New(apierr.CodeInvalidRequest, "cover image cannot be compressed below 256 KB")
+}
+
+// fitDimensions scales width x height to fit in max x max. Neither side goes below 1, even for
+// extreme aspect ratios.
+func fitDimensions(width, height, max int) (int, int) {
+	if width >= height {
+		return max, clampSide(height * max / width)
+	}
+	return clampSide(width * max / height), max
+}
+
+func clampSide(side int) int {
+	if side < 1 {
+		return 1
+	}
+	return side
+}
+
+// resizeImage scales src to width x height by averaging the source pixels covered by each
+// destination pixel (a box filter), which is good enough for downscaling cover art
+func resizeImage(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// uploadPlaylistCover sets the playlist cover; cover must already be a base64 encoded JPEG
+func (h *Handlers) uploadPlaylistCover(ctx context.Context, playlistID string, cover []byte, token string) error {
+	url := fmt.Sprintf("%s/v1/playlists/%s/images", spotifyApiURL, playlistID)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(cover))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "image/jpeg")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return apierr.FromSpotify(resp)
+	}
+	return nil
+}
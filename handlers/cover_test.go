@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestFitDimensions(t *testing.T) {
+	tests := []struct {
+		width, height, max int
+		wantW, wantH       int
+	}{
+		{640, 640, 640, 640, 640},
+		{1000, 500, 640, 640, 320},
+		{500, 1000, 640, 320, 640},
+		{300, 200, 640, 640, 426},
+		{2000, 1, 640, 640, 1},
+		{1, 2000, 640, 1, 640},
+	}
+	for _, tt := range tests {
+		w, h := fitDimensions(tt.width, tt.height, tt.max)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("fitDimensions(%d, %d, %d) = %d, %d, want %d, %d", tt.width, tt.height, tt.max, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+// pngHeader is the start of a PNG of the given size, enough for image.DecodeConfig but not
+// for decoding
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 2 // truecolor
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestDecodeImage(t *testing.T) {
+	small := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	var pngData, jpegData bytes.Buffer
+	if err := png.Encode(&pngData, small); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, small, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		raw          []byte
+		wantErr      bool
+		wantTooLarge bool
+	}{
+		{"png", pngData.Bytes(), false, false},
+		{"jpeg", jpegData.Bytes(), false, false},
+		{"too many pixels", pngHeader(5000, 5000), true, true},
+		{"one side too long", pngHeader(1, 1<<30), true, true},
+		// Passes the size check, then fails for lack of pixel data
+		{"largest allowed", pngHeader(4096, 4096), true, false},
+		{"not an image", []byte("hello"), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := decodeImage(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeImage() error = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errImageTooLarge) != tt.wantTooLarge {
+				t.Errorf("decodeImage() error = %v, want too large %v", err, tt.wantTooLarge)
+			}
+			if err == nil && img.Bounds() != small.Bounds() {
+				t.Errorf("decoded bounds %v, want %v", img.Bounds(), small.Bounds())
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package handlers
+
+import "app/apierr"
+
+// artistError reports a failed lookup of an artist's data. Spotify answers 404 for an unknown
+// artist ID, which the client should see as artist_not_found rather than a generic not_found.
+func artistError(err error, message string) error {
+	if apierr.HasCode(err, apierr.CodeNotFound) {
+		return &apierr.Error{Code: apierr.CodeArtistNotFound, Message: "Artist not found", Err: err}
+	}
+	return apierr.Wrap(err, apierr.CodeInternal, message)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	essentialsDefaultSize = 30
+	essentialsMaxSize     = 100
+
+	// Spotify accepts at most 50 IDs per /v1/tracks call
+	tracksBatchSize = 50
+)
+
+// FullTrack is the track object returned by /v1/tracks and /v1/artists/{id}/top-tracks
+type FullTrack struct {
+	SimplifiedTrack
+	Popularity int             `json:"popularity"`
+	Album      SimplifiedAlbum `json:"album"`
+}
+
+type TopTracksResponse struct {
+	Tracks []FullTrack `json:"tracks"`
+}
+
+type SeveralTracksResponse struct {
+	Tracks []*FullTrack `json:"tracks"`
+}
+
+// getEssentialTracks builds an "essentials" list out of the artist's top tracks and the most
+// popular track of every album, ordered by popularity and capped at size. The returned reasons
+// explain, by track ID, why each track was picked or left out.
+func (h *Handlers) getEssentialTracks(ctx context.Context, artistID, token string, tracks []SimplifiedTrack, size int) ([]SimplifiedTrack, map[string]string, error) {
+	ids := make([]string, 0, len(tracks))
+	for _, t := range tracks {
+		ids = append(ids, t.ID)
+	}
+	popularity, err := h.getTracksPopularity(ctx, ids, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	topTracks, err := h.getArtistTopTracks(ctx, artistID, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 1. Most popular track of each album
+	reasons := make(map[string]string, len(tracks))
+	bestOfAlbum := make(map[string]SimplifiedTrack)
+	for _, t := range tracks {
+		reasons[t.ID] = "not the most popular track on its album"
+		best, ok := bestOfAlbum[t.AlbumID]
+		if !ok || popularity[t.ID] > popularity[best.ID] {
+			bestOfAlbum[t.AlbumID] = t
+		}
+	}
+	albumPicks := make([]SimplifiedTrack, 0, len(bestOfAlbum))
+	for _, t := range bestOfAlbum {
+		albumPicks = append(albumPicks, t)
+	}
+	sort.SliceStable(albumPicks, func(i, j int) bool {
+		return popularity[albumPicks[i].ID] > popularity[albumPicks[j].ID]
+	})
+
+	// 2. Top tracks first, then album picks; the same song is often released both as a
+	// single and on an album, so dedupe by name as well as by ID
+	result := make([]SimplifiedTrack, 0, size)
+	seen := make(map[string]struct{})
+	add := func(t SimplifiedTrack, reason string) {
+		key := strings.ToLower(strings.TrimSpace(t.Name))
+		if _, found := seen[t.ID]; found {
+			return
+		}
+		if _, found := seen[key]; found {
+			reasons[t.ID] = "same song is already included"
+			return
+		}
+		if len(result) >= size {
+			reasons[t.ID] = "over the size limit"
+			return
+		}
+		seen[t.ID] = struct{}{}
+		seen[key] = struct{}{}
+		reasons[t.ID] = reason
+		result = append(result, t)
+	}
+	for _, ft := range topTracks {
+		t := ft.SimplifiedTrack
+		t.AlbumID = ft.Album.ID
+		add(t, "top track")
+	}
+	for _, t := range albumPicks {
+		add(t, "most popular track on its album")
+	}
+	return result, reasons, nil
+}
+
+func (h *Handlers) getArtistTopTracks(ctx context.Context, artistID, token string) ([]FullTrack, error) {
+	url := fmt.Sprintf("%s/v1/artists/%s/top-tracks?market=from_token", spotifyApiURL, artistID)
+	var response TopTracksResponse
+	if err := h.makeAPIRequest(ctx, url, token, &response); err != nil {
+		return nil, err
+	}
+	return response.Tracks, nil
+}
+
+// getTracksPopularity returns the popularity of each track, keyed by track ID
+func (h *Handlers) getTracksPopularity(ctx context.Context, ids []string, token string) (map[string]int, error) {
+	popularity := make(map[string]int, len(ids))
+	for i := 0; i < len(ids); i += tracksBatchSize {
+		end := i + tracksBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		url := fmt.Sprintf("%s/v1/tracks?ids=%s", spotifyApiURL, strings.Join(ids[i:end], ","))
+		var response SeveralTracksResponse
+		if err := h.makeAPIRequest(ctx, url, token, &response); err != nil {
+			return nil, err
+		}
+		for _, t := range response.Tracks {
+			if t != nil {
+				popularity[t.ID] = t.Popularity
+			}
+		}
+	}
+	return popularity, nil
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// spotifyStub answers Spotify API calls from handlers keyed by path, whatever the host. A
+// handler returning a stubStatus fails the call with that status.
+type spotifyStub map[string]func(r *http.Request) any
+
+type stubStatus int
+
+func (s spotifyStub) RoundTrip(r *http.Request) (*http.Response, error) {
+	handler, found := s[r.URL.Path]
+	if !found {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("{}")), Request: r}, nil
+	}
+	rec := httptest.NewRecorder()
+	body := handler(r)
+	if status, ok := body.(stubStatus); ok {
+		rec.WriteHeader(int(status))
+	}
+	_ = json.NewEncoder(rec).Encode(body)
+	resp := rec.Result()
+	resp.Request = r
+	return resp, nil
+}
+
+func newStubbedHandlers(stub spotifyStub) *Handlers {
+	return New(nil, nil, nil, &http.Client{Transport: stub}, NewJobs())
+}
+
+type essentialsTrack struct {
+	id, name, album string
+	popularity      int
+}
+
+func TestGetEssentialTracks(t *testing.T) {
+	tests := []struct {
+		name        string
+		tracks      []essentialsTrack
+		top         []essentialsTrack // may include tracks on other artists' albums
+		size        int
+		want        []string
+		wantReasons map[string]string
+	}{
+		{
+			name: "most popular track of each album, by popularity",
+			tracks: []essentialsTrack{
+				{"a1", "Intro", "A", 50}, {"a2", "Hit", "A", 80},
+				{"b1", "Deep Cut", "B", 30},
+				{"c1", "Other", "C", 60},
+			},
+			size: 10,
+			want: []string{"a2", "c1", "b1"},
+			wantReasons: map[string]string{
+				"a1": "not the most popular track on its album",
+				"a2": "most popular track on its album",
+			},
+		},
+		{
+			name:   "top tracks come first",
+			tracks: []essentialsTrack{{"a1", "Intro", "A", 50}, {"a2", "Hit", "A", 80}, {"b1", "Deep Cut", "B", 30}},
+			top:    []essentialsTrack{{"b1", "Deep Cut", "B", 30}, {"f1", "Feature", "F", 90}},
+			size:   10,
+			want:   []string{"b1", "f1", "a2"},
+			wantReasons: map[string]string{
+				"b1": "top track",
+				"f1": "top track",
+				"a2": "most popular track on its album",
+			},
+		},
+		{
+			name:   "same ID only once",
+			tracks: []essentialsTrack{{"a1", "Hit", "A", 80}},
+			top:    []essentialsTrack{{"a1", "Hit", "A", 80}},
+			size:   10,
+			want:   []string{"a1"},
+			wantReasons: map[string]string{
+				"a1": "top track",
+			},
+		},
+		{
+			name: "same song on a single and an album",
+			tracks: []essentialsTrack{
+				{"s1", "Hit", "Single", 70},
+				{"a1", " hit ", "A", 60}, {"a2", "Intro", "A", 10},
+			},
+			size: 10,
+			want: []string{"s1"},
+			wantReasons: map[string]string{
+				"a1": "same song is already included",
+				"a2": "not the most popular track on its album",
+			},
+		},
+		{
+			name:   "capped at size",
+			tracks: []essentialsTrack{{"a1", "One", "A", 80}, {"b1", "Two", "B", 70}, {"c1", "Three", "C", 60}},
+			top:    []essentialsTrack{{"c1", "Three", "C", 60}},
+			size:   2,
+			want:   []string{"c1", "a1"},
+			wantReasons: map[string]string{
+				"c1": "top track",
+				"a1": "most popular track on its album",
+				"b1": "over the size limit",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			popularity := make(map[string]int)
+			var tracks []SimplifiedTrack
+			for _, et := range tt.tracks {
+				popularity[et.id] = et.popularity
+				tracks = append(tracks, SimplifiedTrack{ID: et.id, Name: et.name, AlbumID: et.album})
+			}
+			var top []FullTrack
+			for _, et := range tt.top {
+				top = append(top, FullTrack{
+					SimplifiedTrack: SimplifiedTrack{ID: et.id, Name: et.name},
+					Popularity:      et.popularity,
+					Album:           SimplifiedAlbum{ID: et.album},
+				})
+			}
+			h := newStubbedHandlers(spotifyStub{
+				"/v1/tracks": func(r *http.Request) any {
+					var response SeveralTracksResponse
+					for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+						response.Tracks = append(response.Tracks, &FullTrack{SimplifiedTrack: SimplifiedTrack{ID: id}, Popularity: popularity[id]})
+					}
+					return response
+				},
+				"/v1/artists/artist/top-tracks": func(*http.Request) any {
+					return TopTracksResponse{Tracks: top}
+				},
+			})
+
+			got, reasons, err := h.getEssentialTracks(context.Background(), "artist", "token", tracks, tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, track := range got {
+				ids = append(ids, track.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("tracks = %v, want %v", ids, tt.want)
+			}
+			for id, want := range tt.wantReasons {
+				if reasons[id] != want {
+					t.Errorf("reason for %s = %q, want %q", id, reasons[id], want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetEssentialTracksTopTrackAlbum(t *testing.T) {
+	// Top tracks come without album_id, it is taken from their album
+	h := newStubbedHandlers(spotifyStub{
+		"/v1/tracks": func(*http.Request) any { return SeveralTracksResponse{} },
+		"/v1/artists/artist/top-tracks": func(*http.Request) any {
+			return TopTracksResponse{Tracks: []FullTrack{{SimplifiedTrack: SimplifiedTrack{ID: "f1", Name: "Feature"}, Album: SimplifiedAlbum{ID: "F"}}}}
+		},
+	})
+	got, _, err := h.getEssentialTracks(context.Background(), "artist", "token", nil, essentialsDefaultSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].AlbumID != "F" {
+		t.Errorf("tracks = %+v, want f1 on album F", got)
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	healthCheckTimeout = 2 * time.Second
+	// A Spotify response within this window counts as reachable without probing again
+	spotifyReachableWindow = 5 * time.Minute
+	spotifyProbeURL        = "https://accounts.spotify.com/"
+)
+
+const (
+	CheckOK       = "ok"
+	CheckDegraded = "degraded" // failing, but the service can still serve requests
+	CheckFailed   = "failed"
+)
+
+type HealthCheck struct {
+	Status    string     `json:"status"`
+	LatencyMS int64      `json:"latency_ms,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Healthz only reports that the process is up and serving
+func (h *Handlers) Healthz(c fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "ok"})
+}
+
+// Readyz reports whether the service can do useful work: Spotify answers or answered recently
+// and the configuration is loaded. Redis being down only degrades it.
+func (h *Handlers) Readyz(c fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]HealthCheck{
+		"config":  h.checkConfig(),
+		"redis":   h.checkRedis(ctx),
+		"spotify": h.checkSpotify(ctx),
+	}
+	status, ready := "ready", true
+	for _, check := range checks {
+		if check.Status == CheckFailed {
+			status, ready = "not_ready", false
+		}
+	}
+	code := fiber.StatusOK
+	if !ready {
+		code = fiber.StatusServiceUnavailable
+	}
+	return c.Status(code).JSON(fiber.Map{"status": status, "checks": checks})
+}
+
+func (h *Handlers) checkConfig() HealthCheck {
+	if h.cfg == nil {
+		return HealthCheck{Status: CheckFailed, Error: "configuration not loaded"}
+	}
+	// Anything loaded has passed validation
+	return HealthCheck{Status: CheckOK}
+}
+
+// checkRedis never fails readiness. Without Redis the cache serves from memory (memory and
+// tiered backends) or falls back to crawling Spotify (redis backend), rate limits are let
+// through, and only stored state like history, subscriptions and webhooks is unavailable.
+// Every replica shares the same Redis, so pulling them from rotation wouldn't help.
+func (h *Handlers) checkRedis(ctx context.Context) HealthCheck {
+	start := time.Now()
+	err := h.redis.Ping(ctx).Err()
+	check := HealthCheck{Status: CheckOK, LatencyMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		check.Status = CheckDegraded
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func (h *Handlers) checkSpotify(ctx context.Context) HealthCheck {
+	if last := h.spotifyReachableAt.Load(); last != 0 && time.Since(time.Unix(0, last)) < spotifyReachableWindow {
+		lastSeen := time.Unix(0, last).UTC()
+		return HealthCheck{Status: CheckOK, LastSeen: &lastSeen}
+	}
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", spotifyProbeURL, nil)
+	if err != nil {
+		return HealthCheck{Status: CheckFailed, Error: err.Error()}
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return HealthCheck{Status: CheckFailed, Error: err.Error()}
+	}
+	resp.Body.Close()
+	lastSeen := time.Now().UTC()
+	return HealthCheck{Status: CheckOK, LatencyMS: lastSeen.Sub(start).Milliseconds(), LastSeen: &lastSeen}
+}
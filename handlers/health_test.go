@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"app/cache"
+	"app/config"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestCheckRedisDown(t *testing.T) {
+	// Nothing listens on a port we just closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := closed.Addr().String()
+	closed.Close()
+
+	for _, backend := range []string{cache.BackendRedis, cache.BackendTiered, cache.BackendMemory} {
+		t.Run(backend, func(t *testing.T) {
+			client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+			defer client.Close()
+			cfg := &config.Config{Cache: config.CacheConfig{Backend: backend}}
+			h := New(cfg, client, nil, nil, NewJobs())
+
+			check := h.checkRedis(context.Background())
+			if check.Status != CheckDegraded || check.Error == "" {
+				t.Errorf("checkRedis() = %+v, want degraded with the error", check)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/apierr"
+	"app/middleware"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	OperationCreate  = "create"
+	OperationModify  = "modify"
+	OperationRestore = "restore"
+
+	maxPlaylistSnapshots = 50
+	playlistHistoryTTL   = 30 * 24 * time.Hour
+)
+
+// PlaylistSnapshot is the state of a playlist right before we changed it. Restoring it undoes
+// Operation and everything that came after.
+type PlaylistSnapshot struct {
+	ID         string `json:"id"`
+	Operation  string `json:"operation"`   // the change that was applied after the snapshot
+	SnapshotID string `json:"snapshot_id"` // Spotify's snapshot ID at that time
+	// URIs has every item in playlist order, local files and episodes included. Items Spotify
+	// returned without a track are "".
+	URIs      []string  `json:"uris"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RestorePlaylistRequest struct {
+	Snapshot string `json:"snapshot"`
+	// Force restores even if items that can't be added through the API, like local files, are lost
+	Force bool `json:"force"`
+}
+
+// isRestorable reports whether the Web API can add uri to a playlist. Local files can only be
+// added from the Spotify apps.
+func isRestorable(uri string) bool {
+	return uri != "" && !strings.HasPrefix(uri, "spotify:local:")
+}
+
+// lostOnRestore counts the items a restore from target would drop: the unrestorable items of
+// target, and those of the current playlist, which the restore replaces
+func lostOnRestore(current, target []string) int {
+	lost := 0
+	for _, uri := range slices.Concat(current, target) {
+		if !isRestorable(uri) {
+			lost++
+		}
+	}
+	return lost
+}
+
+// History is kept per user, so nobody can read or restore snapshots taken by someone else
+func playlistHistoryKey(userID, playlistID string) string {
+	return fmt.Sprintf("playlist_history:%s:%s", userID, playlistID)
+}
+
+// recordPlaylistSnapshot stores uris as the playlist's latest snapshot and returns its ID
+func (h *Handlers) recordPlaylistSnapshot(ctx context.Context, userID, playlistID, operation, snapshotID string, uris []string) (string, error) {
+	if uris == nil {
+		uris = []string{}
+	}
+	now := time.Now().UTC()
+	snapshot := PlaylistSnapshot{
+		ID:         strconv.FormatInt(now.UnixNano(), 10),
+		Operation:  operation,
+		SnapshotID: snapshotID,
+		URIs:       uris,
+		CreatedAt:  now,
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+
+	key := playlistHistoryKey(userID, playlistID)
+	pipe := h.redis.TxPipeline()
+	pipe.LPush(ctx, key, data)
+	pipe.LTrim(ctx, key, 0, maxPlaylistSnapshots-1)
+	pipe.Expire(ctx, key, playlistHistoryTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return snapshot.ID, nil
+}
+
+// getPlaylistHistory returns the recorded snapshots, newest first
+func (h *Handlers) getPlaylistHistory(ctx context.Context, userID, playlistID string) ([]PlaylistSnapshot, error) {
+	entries, err := h.redis.LRange(ctx, playlistHistoryKey(userID, playlistID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]PlaylistSnapshot, 0, len(entries))
+	for _, entry := range entries {
+		var snapshot PlaylistSnapshot
+		if err := json.Unmarshal([]byte(entry), &snapshot); err != nil {
+			slog.WarnContext(ctx, "skipping corrupt playlist snapshot", "playlist_id", playlistID, "err", err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func (h *Handlers) GetPlaylistHistory(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	history, err := h.getPlaylistHistory(ctx, user.ID, c.Params("id"))
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to load playlist history")
+	}
+	return c.Status(fiber.StatusOK).JSON(history)
+}
+
+func (h *Handlers) RestorePlaylist(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	playlistID := c.Params("id")
+	var req RestorePlaylistRequest
+	if err := c.Bind().Body(&req); err != nil || req.Snapshot == "" {
+		return apierr.New(apierr.CodeInvalidRequest, "snapshot is required")
+	}
+
+	// 1. Find the snapshot to go back to
+	history, err := h.getPlaylistHistory(ctx, user.ID, playlistID)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to load playlist history")
+	}
+	var target *PlaylistSnapshot
+	for i := range history {
+		if history[i].ID == req.Snapshot {
+			target = &history[i]
+			break
+		}
+	}
+	if target == nil {
+		return apierr.New(apierr.CodeSnapshotNotFound, "Snapshot not found")
+	}
+
+	// 2. Record the current state first, so the restore itself can be undone. The restore
+	// replaces every item, so refuse it if that loses items the API can't add back.
+	snapshotID, err := h.getPlaylistSnapshotID(ctx, playlistID, user.TOKEN)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to fetch playlist")
+	}
+	current, err := h.getPlaylistItems(ctx, playlistID, user.TOKEN)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to fetch playlist tracks")
+	}
+	currentURIs := itemURIs(current)
+	if lost := lostOnRestore(currentURIs, target.URIs); lost > 0 && !req.Force {
+		return apierr.New(apierr.CodeSnapshotUnrestorable, fmt.Sprintf(
+			"Restoring would remove %d local files or unavailable items that can't be added back through the API; send force to restore anyway", lost))
+	}
+	previous, err := h.recordPlaylistSnapshot(ctx, user.ID, playlistID, OperationRestore, snapshotID, currentURIs)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to record snapshot")
+	}
+
+	// 3. Replace the playlist contents with the first 100 items, then append the rest
+	uris := slices.DeleteFunc(slices.Clone(target.URIs), func(uri string) bool { return !isRestorable(uri) })
+	const batchSize = 100
+	if _, err := h.replacePlaylistTracks(ctx, playlistID, uris[:min(batchSize, len(uris))], user.TOKEN); err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to restore playlist")
+	}
+	for i := batchSize; i < len(uris); i += batchSize {
+		end := min(i+batchSize, len(uris))
+		if err := h.addTracksToPlaylist(ctx, playlistID, uris[i:end], user.TOKEN); err != nil {
+			// The playlist now holds only the first i items of the snapshot
+			apiErr := apierr.From(err)
+			return &apierr.Error{
+				Code:       apiErr.Code,
+				Message:    fmt.Sprintf("Playlist was only partly restored (%d of %d items); restore snapshot %s to go back to how it was before", i, len(uris), previous),
+				RetryAfter: apiErr.RetryAfter,
+				Err:        err,
+			}
+		}
+	}
+	slog.InfoContext(ctx, "restored playlist snapshot", "playlist_id", playlistID, "snapshot_id", target.ID, "tracks", len(uris))
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message":  fmt.Sprintf("Playlist restored to snapshot %s", target.ID),
+		"snapshot": target.ID,
+		"count":    len(uris),
+		"previous": previous,
+	})
+}
+
+// replacePlaylistTracks replaces the whole playlist with up to 100 uris
+func (h *Handlers) replacePlaylistTracks(ctx context.Context, playlistID string, uris []string, token string) (string, error) {
+	url := fmt.Sprintf("%s/v1/playlists/%s/tracks", spotifyApiURL, playlistID)
+	return h.sendPlaylistTracksRequest(ctx, "PUT", url, AddTracksBody{URIs: uris}, token)
+}
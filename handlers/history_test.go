@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestLostOnRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []string
+		target  []string
+		want    int
+	}{
+		{"nothing lost", []string{"spotify:track:a"}, []string{"spotify:track:b", "spotify:episode:c"}, 0},
+		{"local file in target", []string{"spotify:track:a"}, []string{"spotify:local:artist:album:song:180"}, 1},
+		{"unavailable item in target", nil, []string{"spotify:track:a", ""}, 1},
+		{"local file in current playlist", []string{"spotify:local:artist:album:song:180", "spotify:track:a"}, []string{"spotify:track:a"}, 1},
+		{"both", []string{"", "spotify:local:a:b:c:1"}, []string{"spotify:local:a:b:c:1"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lostOnRestore(tt.current, tt.target); got != tt.want {
+				t.Errorf("lostOnRestore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -1 +1,62 @@
 package handlers
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"app/cache"
+	"app/config"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultSpotifyTimeout applies when New gets no Spotify client
+const defaultSpotifyTimeout = 10 * time.Second
+
+// Handlers serves the API and runs the background jobs. Everything it depends on is passed to
+// New, so it can be built on its own.
+type Handlers struct {
+	cfg   *config.Config
+	redis *redis.Client
+	cache cache.Cache
+	// httpClient makes the Spotify calls, see observeSpotify
+	httpClient *http.Client
+	jobs       *Jobs
+	// webhookClient delivers webhooks, see newWebhookClient
+	webhookClient        *http.Client
+	allowPrivateWebhooks bool
+
+	// refreshingArtists holds the artists with a background refresh in flight
+	refreshingArtists sync.Map
+	// artistLoads coalesces concurrent cache misses for the same artist within this process
+	artistLoads singleflight.Group
+	// spotifyReachableAt is the unix nano time of the last Spotify response
+	spotifyReachableAt atomic.Int64
+	// appToken is the app token used for warming, shared until shortly before it expires
+	appToken struct {
+		mu      sync.Mutex
+		token   string
+		expires time.Time
+	}
+}
+
+// New builds the handlers. Spotify calls go through spotifyClient, or a client with a
+// 10 second timeout if it is nil. Background jobs are started on jobs.
+func New(cfg *config.Config, redisClient *redis.Client, c cache.Cache, spotifyClient *http.Client, jobs *Jobs) *Handlers {
+	if spotifyClient == nil {
+		spotifyClient = &http.Client{Timeout: defaultSpotifyTimeout}
+	}
+	h := &Handlers{cfg: cfg, redis: redisClient, cache: c, jobs: jobs}
+	h.allowPrivateWebhooks = cfg != nil && cfg.Webhooks.AllowPrivateAddresses
+	h.webhookClient = newWebhookClient(h.allowPrivateWebhooks)
+	h.httpClient = observeSpotify(spotifyClient, &h.spotifyReachableAt)
+	return h
+}
+
+// SpotifyClient is the client the handlers call Spotify with, for middleware that does too
+func (h *Handlers) SpotifyClient() *http.Client {
+	return h.httpClient
+}
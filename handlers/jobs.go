@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"app/logging"
+	"app/metrics"
+)
+
+// Job names, as reported by the background_jobs metric
+const (
+	jobArtistRefresh         = "artist_refresh"
+	jobWebhookDelivery       = "webhook_delivery"
+	jobCacheWarm             = "cache_warm"
+	jobSubscriptionScheduler = "subscription_scheduler"
+)
+
+// Jobs tracks background work (cache refreshes, webhook deliveries, cache warming, the
+// subscription scheduler) so shutdown can wait for it. Its context is cancelled when shutdown
+// starts; long jobs check stopping() and stop at a point the next run can pick up from. Each
+// server has its own.
+type Jobs struct {
+	mu     sync.Mutex
+	closed bool // set by Drain, no jobs start after it
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewJobs() *Jobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Jobs{ctx: ctx, cancel: cancel}
+}
+
+// run runs fn in the background. fn gets ctx's log attributes but not its cancellation, so
+// a job started by a request outlives it and its I/O is not cut off by shutdown. Once shutdown
+// has started, requests still in flight can't start jobs: they would run against closed
+// connections, and Drain may already be waiting.
+func (j *Jobs) run(ctx context.Context, name string, fn func(ctx context.Context)) {
+	ctx = logging.With(context.WithoutCancel(ctx), "job", name)
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		slog.WarnContext(ctx, "not starting background job, shutting down")
+		return
+	}
+	j.wg.Add(1)
+	j.mu.Unlock()
+
+	running := metrics.BackgroundJobs.WithLabelValues(name)
+	running.Inc()
+	go func() {
+		defer j.wg.Done()
+		defer running.Dec()
+		fn(ctx)
+	}()
+}
+
+// stopping reports whether shutdown has started
+func (j *Jobs) stopping() bool {
+	return j.ctx.Err() != nil
+}
+
+// Drain asks background jobs to stop, refuses new ones and waits for the running ones until
+// ctx is done
+func (j *Jobs) Drain(ctx context.Context) error {
+	j.mu.Lock()
+	j.closed = true
+	j.mu.Unlock()
+	j.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
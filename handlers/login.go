@@ -1,14 +1,17 @@
 package handlers
 
 import (
-	"app/config"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 
-	utils "github.com/ItsMeSamey/go_utils"
+	"app/apierr"
+	"app/middleware"
+
 	"github.com/gofiber/fiber/v3"
 )
 
@@ -26,66 +29,74 @@ type TokenResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-func Login(c fiber.Ctx) error {
+func (h *Handlers) Login(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
 	var req CODE
 	if err := c.Bind().Body(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":   utils.WithStack(err),
-			"message": "Invalid request body",
-		})
+		return apierr.Wrap(err, apierr.CodeInvalidRequest, "Invalid request body")
 	}
 	if req.Code == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error":   "Code is required",
-			"message": "Please provide a valid code",
-		})
+		return apierr.New(apierr.CodeInvalidRequest, "Code is required")
 	}
 
 	// --- Exchange Code for Access Token ---
+	redirectURI := h.cfg.Spotify.RedirectURI // e.g., "http://127.0.0.1:3000/callback"
 
-	// 1. Get credentials from environment variables for security
-	clientID := config.Getenv("SPOTIFY_CLIENT_ID")
-	clientSecret := config.Getenv("SPOTIFY_CLIENT_SECRET")
-	redirectURI := config.Getenv("SPOTIFY_REDIRECT_URI") // e.g., "http://127.0.0.1:3000/callback"
-
-	// 2. Prepare the request body for Spotify's token endpoint
 	data := url.Values{}
 	data.Set("grant_type", "authorization_code")
 	data.Set("code", req.Code)
 	data.Set("redirect_uri", redirectURI)
 
-	// 3. Create the HTTP request
+	tokenResponse, err := h.requestSpotifyToken(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	// Keep the refresh token so background jobs (subscriptions) can act for the user later
+	if tokenResponse.RefreshToken != "" {
+		if err := h.storeRefreshToken(ctx, tokenResponse.AccessToken, tokenResponse.RefreshToken); err != nil {
+			slog.WarnContext(ctx, "could not store refresh token", "err", err)
+		}
+	}
+
+	// Return the tokens to the frontend
+	return c.Status(fiber.StatusOK).JSON(tokenResponse)
+}
+
+// requestSpotifyToken calls Spotify's token endpoint with the app credentials
+func (h *Handlers) requestSpotifyToken(ctx context.Context, data url.Values) (*TokenResponse, error) {
+	// 1. Get credentials from the configuration
+	clientID := h.cfg.Spotify.ClientID
+	clientSecret := h.cfg.Spotify.ClientSecret
+
+	// 2. Create the HTTP request
 	tokenURL := "https://accounts.spotify.com/api/token"
-	r, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	r, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create request"})
+		return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to create token request")
 	}
 
-	// 4. Set the required headers, including the Authorization header
+	// 3. Set the required headers, including the Authorization header
 	authHeader := base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret))
 	r.Header.Add("Authorization", "Basic "+authHeader)
 	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	// 5. Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(r)
+	// 4. Execute the request
+	resp, err := h.httpClient.Do(r)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get token from Spotify"})
+		return nil, apierr.Wrap(err, apierr.CodeSpotifyError, "Failed to get token from Spotify")
 	}
 	defer resp.Body.Close()
 
-	// 6. Check for non-200 responses from Spotify
+	// 5. Check for non-200 responses from Spotify
 	if resp.StatusCode != http.StatusOK {
-		// You can add more detailed error handling here by reading resp.Body
-		return c.Status(resp.StatusCode).JSON(fiber.Map{"error": "Spotify returned an error"})
+		return nil, apierr.FromSpotify(resp)
 	}
 
-	// 7. Decode the successful JSON response into our struct
+	// 6. Decode the successful JSON response into our struct
 	var tokenResponse TokenResponse
 	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to decode Spotify response"})
+		return nil, apierr.Wrap(err, apierr.CodeSpotifyError, "Failed to decode Spotify response")
 	}
-
-	// 8. Return the tokens to the frontend
-	return c.Status(fiber.StatusOK).JSON(tokenResponse)
-}
\ No newline at end of file
+	return &tokenResponse, nil
+}
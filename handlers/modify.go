@@ -1,142 +1,411 @@
 package handlers
 
 import (
+	"app/apierr"
 	"app/middleware"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"strings"
 
 	"github.com/gofiber/fiber/v3"
 )
 
-func ModifyPlaylist(c fiber.Ctx) error {
+const (
+    ModifyModeAdd    = "add"    // add the artist's missing tracks
+    ModifyModeSync   = "sync"   // add missing tracks and remove everything not by the artist
+    ModifyModeRemove = "remove" // remove all of the artist's tracks
+)
+
+type ModifyPlaylistRequest struct {
+    PlaylistID string `json:"playlist_id"`
+    ArtistURL  string `json:"artist_url"`
+    Mode       string `json:"mode"`    // defaults to "add"
+    DryRun     bool   `json:"dry_run"` // only report what would change
+    Order      string `json:"order"`   // "" appends new tracks, "release_date" keeps the playlist sorted
+    // Resort sorts a playlist that isn't sorted by release date before inserting, at most
+    // maxReorderMoves moves. Without it such playlists are refused.
+    Resort bool `json:"resort"`
+}
+
+/* --------- Structs for Spotify API ---------- */
+type TrackURI struct {
+    URI string `json:"uri"`
+}
+type RemoveTracksBody struct {
+    Tracks     []TrackURI `json:"tracks"`
+    SnapshotID string     `json:"snapshot_id,omitempty"`
+}
+type SnapshotResponse struct {
+    SnapshotID string `json:"snapshot_id"`
+}
+
+type ModifyResult struct {
+    DryRun         bool            `json:"dry_run,omitempty"`
+    Message        string          `json:"message,omitempty"`
+    AddedCount     int             `json:"added_count"`
+    RemovedCount   int             `json:"removed_count"`
+    ReorderedCount int             `json:"reordered_count"`
+    Tracks         []TrackDecision `json:"tracks,omitempty"`
+}
+
+func (h *Handlers) ModifyPlaylist(c fiber.Ctx) error {
+    ctx := middleware.Context(c)
     // Get user info and token
-    userInterface := c.Locals("user")
-    if userInterface == nil {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-            "error": "User not authenticated - no token",
-        })
-    }
-    user, ok := userInterface.(middleware.User)
-    if !ok {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Invalid token data type",
-        })
-    }
-    if user.TOKEN == "" {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-            "error": "User not authenticated - empty token",
-        })
+    user, err := middleware.CurrentUser(c)
+    if err != nil {
+        return err
     }
 
-    // Parse input JSON with playlist_id and artist_url
-    var req struct {
-        PlaylistID string `json:"playlist_id"`
-        ArtistURL  string `json:"artist_url"`
-    }
+    // Parse input JSON with playlist_id, artist_url and mode
+    var req ModifyPlaylistRequest
     if err := c.Bind().Body(&req); err != nil {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+        return apierr.New(apierr.CodeInvalidRequest, "Invalid request body")
     }
     if req.PlaylistID == "" || req.ArtistURL == "" {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "playlist_id and artist_url are required"})
+        return apierr.New(apierr.CodeInvalidRequest, "playlist_id and artist_url are required")
+    }
+
+    switch req.Mode {
+    case "":
+        req.Mode = ModifyModeAdd
+    case ModifyModeAdd, ModifyModeSync, ModifyModeRemove:
+    default:
+        return apierr.New(apierr.CodeInvalidRequest, "Invalid mode")
+    }
+    if req.Order != "" && req.Order != PlaylistOrderReleaseDate {
+        return apierr.New(apierr.CodeInvalidRequest, "Invalid order")
     }
 
     artistID := extractArtistID(req.ArtistURL)
     if artistID == "" {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid artist URL"})
+        return apierr.New(apierr.CodeInvalidRequest, "Invalid artist URL")
     }
 
-    // 1. Fetch all tracks from the artist (filtered by artist)
-    artistTracks, err := getCachedArtistTracks(artistID, user.TOKEN)
+    result, err := h.applyModify(ctx, user.ID, user.TOKEN, artistID, req)
     if err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": fmt.Sprintf("Failed to fetch artist tracks: %v", err),
-        })
+        if !req.DryRun {
+            h.emitWebhookEvent(ctx, user.ID, WebhookEventBuildFailed, BuildEvent{
+                Operation:  OperationModify,
+                PlaylistID: req.PlaylistID,
+                ArtistID:   artistID,
+            }.withError(err))
+        }
+        return err
     }
-    if len(artistTracks) == 0 {
-        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-            "error": "No tracks found for this artist",
+    if !req.DryRun {
+        h.emitWebhookEvent(ctx, user.ID, WebhookEventBuildCompleted, BuildEvent{
+            Operation:    OperationModify,
+            PlaylistID:   req.PlaylistID,
+            ArtistID:     artistID,
+            AddedCount:   result.AddedCount,
+            RemovedCount: result.RemovedCount,
         })
     }
+    return c.Status(fiber.StatusOK).JSON(result)
+}
 
-    // 2. Fetch all existing tracks in the playlist (Spotify playlists can be paginated)
-    playlistTracks, err := getPlaylistTracks(req.PlaylistID, user.TOKEN)
+// applyModify brings the playlist in line with the artist's tracks according to req.Mode.
+// Errors are *apierr.Error.
+func (h *Handlers) applyModify(ctx context.Context, userID, token, artistID string, req ModifyPlaylistRequest) (*ModifyResult, error) {
+    // 1. Fetch all tracks from the artist (filtered by artist)
+    artistTracks, err := h.getCachedArtistTracks(ctx, artistID, token)
     if err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": fmt.Sprintf("Failed to fetch playlist tracks: %v", err),
-        })
+        return nil, artistError(err, "Failed to fetch artist tracks")
+    }
+    if len(artistTracks) == 0 && req.Mode != ModifyModeRemove {
+        return nil, apierr.New(apierr.CodeNoTracks, "No tracks found for this artist")
     }
 
-    // 3. Build sets of existing track IDs and map artist tracks by ID
+    // 2. Fetch all existing tracks in the playlist (Spotify playlists can be paginated).
+    // The snapshot is read first so removals apply to the version we diffed against.
+    snapshotID, err := h.getPlaylistSnapshotID(ctx, req.PlaylistID, token)
+    if err != nil {
+        return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to fetch playlist")
+    }
+    playlistItems, err := h.getPlaylistItems(ctx, req.PlaylistID, token)
+    if err != nil {
+        return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to fetch playlist tracks")
+    }
+    playlistTracks := catalogTracks(playlistItems)
+
+    // 3. Diff the playlist against the artist's tracks
+    artistTrackIDs := make(map[string]struct{}, len(artistTracks))
+    for _, at := range artistTracks {
+        artistTrackIDs[at.ID] = struct{}{}
+    }
     existingTrackIDs := make(map[string]struct{})
     for _, pt := range playlistTracks {
         existingTrackIDs[pt.ID] = struct{}{}
     }
 
-    missingURIs := make([]string, 0)
-    missingNames := make([]string, 0)
-    for _, at := range artistTracks {
-        if _, found := existingTrackIDs[at.ID]; !found {
-            missingURIs = append(missingURIs, at.URI)
-            missingNames = append(missingNames, at.Name)
+    missing := make([]SimplifiedTrack, 0)
+    if req.Mode != ModifyModeRemove {
+        for _, at := range artistTracks {
+            if _, found := existingTrackIDs[at.ID]; !found {
+                missing = append(missing, at)
+            }
         }
     }
 
-    if len(missingURIs) == 0 {
-        return c.Status(fiber.StatusOK).JSON(fiber.Map{
-            "message": "All artist tracks are already in the playlist",
-            "added_count": 0,
-        })
+    staleURIs := make([]string, 0)
+    staleNames := make([]string, 0)
+    stale := make(map[string]struct{})
+    for _, pt := range playlistTracks {
+        _, byArtist := artistTrackIDs[pt.ID]
+        if req.Mode == ModifyModeRemove {
+            byArtist = byArtist || hasArtist(pt.SimplifiedTrack, artistID)
+        }
+        isStale := (req.Mode == ModifyModeSync && !byArtist) || (req.Mode == ModifyModeRemove && byArtist)
+        if _, seen := stale[pt.URI]; isStale && !seen {
+            stale[pt.URI] = struct{}{}
+            staleURIs = append(staleURIs, pt.URI)
+            staleNames = append(staleNames, pt.Name)
+        }
     }
 
-    // 4. Add missing tracks in batches of 100
+    ordered := req.Order == PlaylistOrderReleaseDate && len(missing) > 0
+    var albums map[string]SimplifiedAlbum
+    if req.DryRun || ordered {
+        albums, err = h.getArtistAlbumsByID(ctx, artistID, token)
+        if err != nil {
+            return nil, artistError(err, "Failed to get album release dates")
+        }
+    }
+
+    // 4. When keeping the playlist sorted, work out where each new track goes. Positions count
+    // every item that stays, local files included, as the Spotify API does. An unsorted playlist
+    // is only sorted first if asked to, that can take a call per track.
+    var moves []trackMove
+    var insertions []trackInsertion
+    if ordered {
+        remaining := make([]TrackWithDate, 0, len(playlistItems))
+        for _, item := range playlistItems {
+            if _, found := stale[item.URI]; !found {
+                remaining = append(remaining, TrackWithDate{Track: item.SimplifiedTrack, ReleaseDate: item.Album.ReleaseDate})
+            }
+        }
+        fillReleaseDates(remaining)
+        added := make([]TrackWithDate, 0, len(missing))
+        for _, t := range missing {
+            added = append(added, TrackWithDate{Track: t, ReleaseDate: albums[t.AlbumID].ReleaseDate})
+        }
+        newerFirst := isNewerFirst(remaining)
+        if !isSortedByDate(remaining, newerFirst) {
+            if !req.Resort {
+                return nil, apierr.New(apierr.CodeInvalidRequest, "Playlist is not sorted by release date; set resort to sort it first")
+            }
+            moves = planReorder(remaining, newerFirst)
+            if len(moves) > maxReorderMoves {
+                return nil, apierr.New(apierr.CodeInvalidRequest, fmt.Sprintf("Sorting the playlist would take %d moves, more than the limit of %d", len(moves), maxReorderMoves))
+            }
+        }
+        insertions = planInsertions(remaining, added, newerFirst)
+    }
+
+    if req.DryRun {
+        staleReason := "not by artist"
+        if req.Mode == ModifyModeRemove {
+            staleReason = "by artist"
+        }
+        decisions := describeModifyPlan(missing, playlistTracks, stale, staleReason, albums)
+        positions := make(map[string]int)
+        for _, ins := range insertions {
+            for i, t := range ins.Tracks {
+                positions[t.URI] = ins.Position + i
+            }
+        }
+        for i := range decisions {
+            if position, found := positions[decisions[i].URI]; found && decisions[i].Action == TrackActionAdd {
+                decisions[i].Position = &position
+            }
+        }
+        return &ModifyResult{
+            DryRun:         true,
+            AddedCount:     len(missing),
+            RemovedCount:   len(staleURIs),
+            ReorderedCount: len(moves),
+            Tracks:         decisions,
+        }, nil
+    }
+
+    if len(missing) == 0 && len(staleURIs) == 0 {
+        return &ModifyResult{Message: "Playlist is already up to date"}, nil
+    }
+
+    // Keep the playlist as it was before we touch it, so the change can be undone
+    if _, err := h.recordPlaylistSnapshot(ctx, userID, req.PlaylistID, OperationModify, snapshotID, itemURIs(playlistItems)); err != nil {
+        slog.WarnContext(ctx, "could not record playlist snapshot", "playlist_id", req.PlaylistID, "err", err)
+    }
+
+    // 5. Remove stale tracks in batches of 100
     const batchSize = 100
-    for i := 0; i < len(missingURIs); i += batchSize {
+    for i := 0; i < len(staleURIs); i += batchSize {
         end := i + batchSize
-        if end > len(missingURIs) {
-            end = len(missingURIs)
+        if end > len(staleURIs) {
+            end = len(staleURIs)
         }
-        batch := missingURIs[i:end]
-        if err := addTracksToPlaylist(req.PlaylistID, batch, user.TOKEN); err != nil {
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "error": fmt.Sprintf("Failed to add tracks to playlist: %v", err),
-            })
+        snapshotID, err = h.removeTracksFromPlaylist(ctx, req.PlaylistID, staleURIs[i:end], snapshotID, token)
+        if err != nil {
+            return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to remove tracks from playlist")
         }
-        // Optional: log progress
         for j := i; j < end; j++ {
-            log.Printf("🟢 Added missing track: %s", missingNames[j])
+            slog.DebugContext(ctx, "removed track", "playlist_id", req.PlaylistID, "track", staleNames[j])
+        }
+    }
+
+    // 6. Sort the remaining tracks and insert the missing ones in place
+    for _, move := range moves {
+        snapshotID, err = h.reorderPlaylistTrack(ctx, req.PlaylistID, move, snapshotID, token)
+        if err != nil {
+            return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to reorder playlist")
+        }
+    }
+    for _, ins := range insertions {
+        uris := make([]string, 0, len(ins.Tracks))
+        for _, t := range ins.Tracks {
+            uris = append(uris, t.URI)
+        }
+        snapshotID, err = h.addTracksToPlaylistAt(ctx, req.PlaylistID, uris, ins.Position, token)
+        if err != nil {
+            return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to add tracks to playlist")
+        }
+        for _, t := range ins.Tracks {
+            slog.DebugContext(ctx, "inserted missing track", "playlist_id", req.PlaylistID, "position", ins.Position, "track", t.Name)
+        }
+    }
+
+    // 7. Otherwise append missing tracks in batches of 100
+    if !ordered {
+        missingURIs := make([]string, 0, len(missing))
+        for _, t := range missing {
+            missingURIs = append(missingURIs, t.URI)
+        }
+        for i := 0; i < len(missingURIs); i += batchSize {
+            end := i + batchSize
+            if end > len(missingURIs) {
+                end = len(missingURIs)
+            }
+            batch := missingURIs[i:end]
+            if err := h.addTracksToPlaylist(ctx, req.PlaylistID, batch, token); err != nil {
+                return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to add tracks to playlist")
+            }
+            // Optional: log progress
+            for j := i; j < end; j++ {
+                slog.DebugContext(ctx, "added missing track", "playlist_id", req.PlaylistID, "track", missing[j].Name)
+            }
         }
     }
 
-    return c.Status(fiber.StatusOK).JSON(fiber.Map{
-        "message":     fmt.Sprintf("Added %d missing artist tracks to playlist %s", len(missingURIs), req.PlaylistID),
-        "added_count": len(missingURIs),
-    })
+    return &ModifyResult{
+        Message:        fmt.Sprintf("Added %d and removed %d tracks in playlist %s", len(missing), len(staleURIs), req.PlaylistID),
+        AddedCount:     len(missing),
+        RemovedCount:   len(staleURIs),
+        ReorderedCount: len(moves),
+    }, nil
 }
 
+func hasArtist(track SimplifiedTrack, artistID string) bool {
+    for _, artist := range track.Artists {
+        if artist.ID == artistID {
+            return true
+        }
+    }
+    return false
+}
 
-func getPlaylistTracks(playlistID, token string) ([]SimplifiedTrack, error) {
+// getPlaylistItems returns every item of the playlist in playlist order, including local files,
+// episodes and items Spotify returns without a track, which are left as the zero FullTrack.
+// Indexes in the result are the positions the Spotify API uses.
+func (h *Handlers) getPlaylistItems(ctx context.Context, playlistID, token string) ([]FullTrack, error) {
     type PlaylistTracksResponse struct {
         Items []struct {
-            Track SimplifiedTrack `json:"track"`
+            Track *FullTrack `json:"track"`
         } `json:"items"`
         Next string `json:"next"`
     }
 
-    var tracks []SimplifiedTrack
+    var items []FullTrack
     nextURL := fmt.Sprintf("%s/v1/playlists/%s/tracks?limit=100", spotifyApiURL, playlistID)
 
     for nextURL != "" {
         var response PlaylistTracksResponse
-        err := makeAPIRequest(nextURL, token, &response)
+        err := h.makeAPIRequest(ctx, nextURL, token, &response)
         if err != nil {
             return nil, err
         }
         for _, item := range response.Items {
-            if item.Track.ID != "" {
-                tracks = append(tracks, item.Track)
+            var track FullTrack
+            if item.Track != nil {
+                track = *item.Track
+                track.AlbumID = track.Album.ID
             }
+            items = append(items, track)
         }
         nextURL = response.Next
     }
-    return tracks, nil
+    return items, nil
+}
+
+// catalogTracks drops the items that have no Spotify ID, such as local files
+func catalogTracks(items []FullTrack) []FullTrack {
+    tracks := make([]FullTrack, 0, len(items))
+    for _, item := range items {
+        if item.ID != "" {
+            tracks = append(tracks, item)
+        }
+    }
+    return tracks
+}
+
+// itemURIs returns the URI of every item, "" for items without a track
+func itemURIs(items []FullTrack) []string {
+    uris := make([]string, 0, len(items))
+    for _, item := range items {
+        uris = append(uris, item.URI)
+    }
+    return uris
+}
+
+func (h *Handlers) getPlaylistSnapshotID(ctx context.Context, playlistID, token string) (string, error) {
+    var response SnapshotResponse
+    url := fmt.Sprintf("%s/v1/playlists/%s?fields=snapshot_id", spotifyApiURL, playlistID)
+    if err := h.makeAPIRequest(ctx, url, token, &response); err != nil {
+        return "", err
+    }
+    return response.SnapshotID, nil
+}
+
+// removeTracksFromPlaylist removes every occurrence of uris and returns the new snapshot ID
+func (h *Handlers) removeTracksFromPlaylist(ctx context.Context, playlistID string, uris []string, snapshotID string, token string) (string, error) {
+    url := fmt.Sprintf("%s/v1/playlists/%s/tracks", spotifyApiURL, playlistID)
+    body := RemoveTracksBody{SnapshotID: snapshotID}
+    for _, uri := range uris {
+        body.Tracks = append(body.Tracks, TrackURI{URI: uri})
+    }
+    b, _ := json.Marshal(body)
+
+    req, err := http.NewRequestWithContext(ctx, "DELETE", url, strings.NewReader(string(b)))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := h.httpClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return "", apierr.FromSpotify(resp)
+    }
+    var snapshot SnapshotResponse
+    if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+        return "", err
+    }
+    return snapshot.SnapshotID, nil
 }
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"app/apierr"
+)
+
+const (
+	// PlaylistOrderReleaseDate inserts new tracks where they belong by album release date
+	// instead of appending them
+	PlaylistOrderReleaseDate = "release_date"
+
+	// maxReorderMoves caps how far a resort may go, every move is a Spotify call
+	maxReorderMoves = 100
+)
+
+/* --------- Structs for Spotify API ---------- */
+type AddTracksAtBody struct {
+	URIs     []string `json:"uris"`
+	Position int      `json:"position"`
+}
+type ReorderTracksBody struct {
+	RangeStart   int    `json:"range_start"`
+	InsertBefore int    `json:"insert_before"`
+	RangeLength  int    `json:"range_length"`
+	SnapshotID   string `json:"snapshot_id,omitempty"`
+}
+
+// trackMove moves the track at From so that it ends up at index To
+type trackMove struct {
+	From int
+	To   int
+}
+
+// trackInsertion is a run of consecutive tracks added at a single position
+type trackInsertion struct {
+	Position int
+	Tracks   []SimplifiedTrack
+}
+
+func comesBefore(a, b string, newerFirst bool) bool {
+	if newerFirst {
+		return a > b
+	}
+	return a < b
+}
+
+// isNewerFirst guesses the direction a playlist is sorted in. Playlists we create are newest
+// first, so that wins ties.
+func isNewerFirst(tracks []TrackWithDate) bool {
+	older, newer := 0, 0
+	for i := 1; i < len(tracks); i++ {
+		switch {
+		case tracks[i].ReleaseDate > tracks[i-1].ReleaseDate:
+			older++
+		case tracks[i].ReleaseDate < tracks[i-1].ReleaseDate:
+			newer++
+		}
+	}
+	return newer >= older
+}
+
+// fillReleaseDates gives items without a release date, like local files and episodes, the date
+// of the item before them (of the first dated item at the start). They then stay next to their
+// neighbours and don't make a sorted playlist look unsorted.
+func fillReleaseDates(tracks []TrackWithDate) {
+	previous := ""
+	for i := range tracks {
+		if tracks[i].ReleaseDate == "" {
+			tracks[i].ReleaseDate = previous
+		}
+		previous = tracks[i].ReleaseDate
+	}
+	next := ""
+	for i := len(tracks) - 1; i >= 0; i-- {
+		if tracks[i].ReleaseDate == "" {
+			tracks[i].ReleaseDate = next
+		}
+		next = tracks[i].ReleaseDate
+	}
+}
+
+func isSortedByDate(tracks []TrackWithDate, newerFirst bool) bool {
+	for i := 1; i < len(tracks); i++ {
+		if comesBefore(tracks[i].ReleaseDate, tracks[i-1].ReleaseDate, newerFirst) {
+			return false
+		}
+	}
+	return true
+}
+
+// planReorder sorts tracks in place and returns the moves that do the same to the playlist.
+// Tracks with equal dates keep their relative order, so an already sorted playlist needs no moves.
+func planReorder(tracks []TrackWithDate, newerFirst bool) []trackMove {
+	var moves []trackMove
+	for i := range tracks {
+		best := i
+		for j := i + 1; j < len(tracks); j++ {
+			if comesBefore(tracks[j].ReleaseDate, tracks[best].ReleaseDate, newerFirst) {
+				best = j
+			}
+		}
+		if best == i {
+			continue
+		}
+		moved := tracks[best]
+		copy(tracks[i+1:best+1], tracks[i:best])
+		tracks[i] = moved
+		moves = append(moves, trackMove{From: best, To: i})
+	}
+	return moves
+}
+
+// planInsertions works out where each added track goes in the sorted playlist. Positions
+// account for the tracks inserted before them, so insertions must be applied in order.
+func planInsertions(current, added []TrackWithDate, newerFirst bool) []trackInsertion {
+	sort.SliceStable(added, func(i, j int) bool {
+		return comesBefore(added[i].ReleaseDate, added[j].ReleaseDate, newerFirst)
+	})
+
+	const batchSize = 100
+	var insertions []trackInsertion
+	lastBase := -1
+	for _, t := range added {
+		// New tracks go after existing tracks released on the same date
+		base := sort.Search(len(current), func(k int) bool {
+			return comesBefore(t.ReleaseDate, current[k].ReleaseDate, newerFirst)
+		})
+		last := len(insertions) - 1
+		if base == lastBase && len(insertions[last].Tracks) < batchSize {
+			insertions[last].Tracks = append(insertions[last].Tracks, t.Track)
+			continue
+		}
+		position := base
+		for _, ins := range insertions {
+			position += len(ins.Tracks)
+		}
+		insertions = append(insertions, trackInsertion{Position: position, Tracks: []SimplifiedTrack{t.Track}})
+		lastBase = base
+	}
+	return insertions
+}
+
+func (h *Handlers) addTracksToPlaylistAt(ctx context.Context, playlistID string, uris []string, position int, token string) (string, error) {
+	url := fmt.Sprintf("%s/v1/playlists/%s/tracks", spotifyApiURL, playlistID)
+	return h.sendPlaylistTracksRequest(ctx, "POST", url, AddTracksAtBody{URIs: uris, Position: position}, token)
+}
+
+// reorderPlaylistTrack moves a single track and returns the new snapshot ID
+func (h *Handlers) reorderPlaylistTrack(ctx context.Context, playlistID string, move trackMove, snapshotID, token string) (string, error) {
+	url := fmt.Sprintf("%s/v1/playlists/%s/tracks", spotifyApiURL, playlistID)
+	body := ReorderTracksBody{
+		RangeStart:   move.From,
+		InsertBefore: move.To,
+		RangeLength:  1,
+		SnapshotID:   snapshotID,
+	}
+	return h.sendPlaylistTracksRequest(ctx, "PUT", url, body, token)
+}
+
+func (h *Handlers) sendPlaylistTracksRequest(ctx context.Context, method, url string, body any, token string) (string, error) {
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(string(b)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return "", apierr.FromSpotify(resp)
+	}
+	var snapshot SnapshotResponse
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return "", err
+	}
+	return snapshot.SnapshotID, nil
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// datedTracks builds tracks with IDs t0, t1, ... and the given release dates
+func datedTracks(dates ...string) []TrackWithDate {
+	tracks := make([]TrackWithDate, len(dates))
+	for i, date := range dates {
+		tracks[i] = TrackWithDate{Track: SimplifiedTrack{ID: fmt.Sprintf("t%d", i)}, ReleaseDate: date}
+	}
+	return tracks
+}
+
+func trackIDs(tracks []TrackWithDate) []string {
+	ids := make([]string, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.Track.ID
+	}
+	return ids
+}
+
+func releaseDates(tracks []TrackWithDate) []string {
+	dates := make([]string, len(tracks))
+	for i, t := range tracks {
+		dates[i] = t.ReleaseDate
+	}
+	return dates
+}
+
+func TestPlanReorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		dates      []string
+		newerFirst bool
+		wantIDs    []string
+		wantMoves  int
+	}{
+		{"empty", nil, true, []string{}, 0},
+		{"sorted newest first", []string{"2022", "2021", "2020"}, true, []string{"t0", "t1", "t2"}, 0},
+		{"sorted oldest first", []string{"2020", "2021", "2022"}, false, []string{"t0", "t1", "t2"}, 0},
+		{"one out of place", []string{"2020", "2022", "2021"}, true, []string{"t1", "t2", "t0"}, 2},
+		{"reversed", []string{"2020", "2021", "2022"}, true, []string{"t2", "t1", "t0"}, 2},
+		{"equal dates keep their order", []string{"2021", "2022", "2021", "2022"}, true, []string{"t1", "t3", "t0", "t2"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := datedTracks(tt.dates...)
+			playlist := slices.Clone(tracks)
+
+			moves := planReorder(tracks, tt.newerFirst)
+
+			if got := trackIDs(tracks); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("sorted tracks = %v, want %v", got, tt.wantIDs)
+			}
+			if len(moves) != tt.wantMoves {
+				t.Errorf("got %d moves %v, want %d", len(moves), moves, tt.wantMoves)
+			}
+			// Spotify moves the track at range_start to before insert_before
+			for _, move := range moves {
+				moved := playlist[move.From]
+				playlist = slices.Insert(slices.Delete(playlist, move.From, move.From+1), move.To, moved)
+			}
+			if got := trackIDs(playlist); !slices.Equal(got, tt.wantIDs) {
+				t.Errorf("playlist after moves = %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestPlanInsertions(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    []string
+		added      []string
+		newerFirst bool
+		want       []trackInsertion // only Position and the number of tracks are compared
+	}{
+		{
+			name:       "into the gaps, newest first",
+			current:    []string{"2023", "2021", "2019"},
+			added:      []string{"2020", "2022"},
+			newerFirst: true,
+			want:       []trackInsertion{{Position: 1, Tracks: make([]SimplifiedTrack, 1)}, {Position: 3, Tracks: make([]SimplifiedTrack, 1)}},
+		},
+		{
+			name:       "into the gaps, oldest first",
+			current:    []string{"2019", "2021"},
+			added:      []string{"2022", "2020"},
+			newerFirst: false,
+			want:       []trackInsertion{{Position: 1, Tracks: make([]SimplifiedTrack, 1)}, {Position: 3, Tracks: make([]SimplifiedTrack, 1)}},
+		},
+		{
+			name:       "after tracks released the same day",
+			current:    []string{"2021", "2021", "2020"},
+			added:      []string{"2021"},
+			newerFirst: true,
+			want:       []trackInsertion{{Position: 2, Tracks: make([]SimplifiedTrack, 1)}},
+		},
+		{
+			name:       "same position is one insertion",
+			current:    []string{"2023", "2021"},
+			added:      []string{"2022", "2022"},
+			newerFirst: true,
+			want:       []trackInsertion{{Position: 1, Tracks: make([]SimplifiedTrack, 2)}},
+		},
+		{
+			name:       "into an empty playlist",
+			current:    nil,
+			added:      []string{"2020", "2021"},
+			newerFirst: true,
+			want:       []trackInsertion{{Position: 0, Tracks: make([]SimplifiedTrack, 2)}},
+		},
+		{
+			name:       "large runs are split into batches",
+			current:    []string{"2023"},
+			added:      slices.Repeat([]string{"2022"}, 150),
+			newerFirst: true,
+			want:       []trackInsertion{{Position: 1, Tracks: make([]SimplifiedTrack, 100)}, {Position: 101, Tracks: make([]SimplifiedTrack, 50)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := datedTracks(tt.current...)
+			added := datedTracks(tt.added...)
+			for i := range added {
+				added[i].Track.ID = fmt.Sprintf("new%d", i)
+			}
+			dates := make(map[string]string)
+			for _, t := range added {
+				dates[t.Track.ID] = t.ReleaseDate
+			}
+
+			insertions := planInsertions(current, added, tt.newerFirst)
+
+			if len(insertions) != len(tt.want) {
+				t.Fatalf("got %d insertions, want %d", len(insertions), len(tt.want))
+			}
+			playlist := slices.Clone(current)
+			for i, ins := range insertions {
+				if ins.Position != tt.want[i].Position || len(ins.Tracks) != len(tt.want[i].Tracks) {
+					t.Errorf("insertion %d = %d tracks at %d, want %d tracks at %d",
+						i, len(ins.Tracks), ins.Position, len(tt.want[i].Tracks), tt.want[i].Position)
+				}
+				inserted := make([]TrackWithDate, len(ins.Tracks))
+				for j, track := range ins.Tracks {
+					inserted[j] = TrackWithDate{Track: track, ReleaseDate: dates[track.ID]}
+				}
+				playlist = slices.Insert(playlist, ins.Position, inserted...)
+			}
+			if !isSortedByDate(playlist, tt.newerFirst) {
+				t.Errorf("playlist after insertions is not sorted: %v", releaseDates(playlist))
+			}
+		})
+	}
+}
+
+func TestFillReleaseDates(t *testing.T) {
+	tests := []struct {
+		name  string
+		dates []string
+		want  []string
+	}{
+		{"all dated", []string{"2022", "2021"}, []string{"2022", "2021"}},
+		{"takes the previous date", []string{"2022", "", "2021", ""}, []string{"2022", "2022", "2021", "2021"}},
+		{"leading items take the first date", []string{"", "", "2021", "2020"}, []string{"2021", "2021", "2021", "2020"}},
+		{"nothing dated", []string{"", ""}, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := datedTracks(tt.dates...)
+			fillReleaseDates(tracks)
+			if got := releaseDates(tracks); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSortedByDate(t *testing.T) {
+	tests := []struct {
+		name       string
+		dates      []string
+		newerFirst bool
+		want       bool
+	}{
+		{"empty", nil, true, true},
+		{"newest first", []string{"2022", "2022", "2021"}, true, true},
+		{"newest first, unsorted", []string{"2021", "2022"}, true, false},
+		{"oldest first", []string{"2021", "2021-05", "2022"}, false, true},
+		{"oldest first, unsorted", []string{"2022", "2021"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSortedByDate(datedTracks(tt.dates...), tt.newerFirst); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+const (
+	TrackActionAdd    = "add"
+	TrackActionRemove = "remove"
+	TrackActionKeep   = "keep"
+)
+
+// TrackDecision describes what a create or modify does (or would do, in dry-run mode) with a
+// single track, and why
+type TrackDecision struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	URI         string `json:"uri"`
+	AlbumID     string `json:"album_id"`
+	AlbumName   string `json:"album_name"`
+	ReleaseDate string `json:"release_date"`
+	Included    bool   `json:"included"` // whether the track ends up in the playlist
+	Action      string `json:"action,omitempty"`
+	Reason      string `json:"reason"`
+	Position    *int   `json:"position,omitempty"` // where an added track is inserted, when keeping order
+}
+
+func newTrackDecision(t SimplifiedTrack, album SimplifiedAlbum, included bool, action, reason string) TrackDecision {
+	return TrackDecision{
+		ID:          t.ID,
+		Name:        t.Name,
+		URI:         t.URI,
+		AlbumID:     t.AlbumID,
+		AlbumName:   album.Name,
+		ReleaseDate: album.ReleaseDate,
+		Included:    included,
+		Action:      action,
+		Reason:      reason,
+	}
+}
+
+// describeCreatePlan lists the selected tracks in playlist order, followed by the artist's
+// tracks that were left out
+func describeCreatePlan(tracks, selected []SimplifiedTrack, reasons map[string]string, albums map[string]SimplifiedAlbum) []TrackDecision {
+	decisions := make([]TrackDecision, 0, len(tracks))
+	included := make(map[string]struct{}, len(selected))
+	for _, t := range selected {
+		reason, ok := reasons[t.ID]
+		if !ok {
+			reason = "by artist"
+		}
+		included[t.ID] = struct{}{}
+		decisions = append(decisions, newTrackDecision(t, albums[t.AlbumID], true, TrackActionAdd, reason))
+	}
+	for _, t := range tracks {
+		if _, found := included[t.ID]; found {
+			continue
+		}
+		decisions = append(decisions, newTrackDecision(t, albums[t.AlbumID], false, "", reasons[t.ID]))
+	}
+	return decisions
+}
+
+// describeModifyPlan lists the tracks to add, followed by every track currently in the playlist
+// and whether it is kept or removed
+func describeModifyPlan(missing []SimplifiedTrack, playlistTracks []FullTrack, stale map[string]struct{}, staleReason string, albums map[string]SimplifiedAlbum) []TrackDecision {
+	decisions := make([]TrackDecision, 0, len(missing)+len(playlistTracks))
+	for _, t := range missing {
+		decisions = append(decisions, newTrackDecision(t, albums[t.AlbumID], true, TrackActionAdd, "missing from playlist"))
+	}
+	for _, pt := range playlistTracks {
+		if _, found := stale[pt.URI]; found {
+			decisions = append(decisions, newTrackDecision(pt.SimplifiedTrack, pt.Album, false, TrackActionRemove, staleReason))
+		} else {
+			decisions = append(decisions, newTrackDecision(pt.SimplifiedTrack, pt.Album, true, TrackActionKeep, "already in playlist"))
+		}
+	}
+	return decisions
+}
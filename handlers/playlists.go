@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"app/apierr"
 	"app/middleware"
 	"encoding/json"
 	"io"
@@ -97,34 +98,15 @@ func GetPlaylistByID(response *PlaylistsResponse, id string) *Playlist {
 }
 
 
-func GetUserPlaylists(c fiber.Ctx) error {
-	// Safe token retrieval with proper error handling
-	userInterface := c.Locals("user")
-
-	if userInterface == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated - no token",
-		})
-	}
-
-	user, ok := userInterface.(middleware.User)
-	if !ok {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Invalid token data type",
-		})
-	}
-
-	if user.TOKEN == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "User not authenticated - empty token",
-		})
+func (h *Handlers) GetUserPlaylists(c fiber.Ctx) error {
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
 	}
 
-	req, err := http.NewRequest("GET", "https://api.spotify.com/v1/me/playlists", nil)
+	req, err := http.NewRequestWithContext(middleware.Context(c), "GET", "https://api.spotify.com/v1/me/playlists", nil)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
+		return apierr.Wrap(utils.WithStack(err), apierr.CodeInternal, "Failed to fetch playlists")
 	}
 
 	// Set the Authorization header with the user's token
@@ -133,31 +115,23 @@ func GetUserPlaylists(c fiber.Ctx) error {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
+		return apierr.Wrap(utils.WithStack(err), apierr.CodeSpotifyError, "Failed to fetch playlists")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch playlists",
-		})
+		return apierr.FromSpotify(resp)
 	}
 
 	// Fixed body reading - use io.ReadAll
 	jsonResponse, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
+		return apierr.Wrap(utils.WithStack(err), apierr.CodeSpotifyError, "Failed to read playlists")
 	}
 
 	response, err := parsePlaylistsResponse(jsonResponse)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
+		return apierr.Wrap(utils.WithStack(err), apierr.CodeSpotifyError, "Failed to read playlists")
 	}
 
 	// Return just the array of playlists as expected by the SolidJS frontend
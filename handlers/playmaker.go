@@ -4,52 +4,91 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"app/apierr"
 
-	"app/config"
 	"app/middleware"
+	"app/tracing"
 
 	utils "github.com/ItsMeSamey/go_utils"
 	"github.com/gofiber/fiber/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
     spotifyApiURL = "https://api.spotify.com"
+
+    // Album groups included when crawling an artist; cached entries record it, see cacheSource
+    artistAlbumGroups = "album,single"
+)
+
+const (
+    // Placeholders {artist} and {date} are substituted in playlist descriptions
+    defaultPlaylistDescription           = "All tracks by {artist}, generated {date}"
+    defaultEssentialsPlaylistDescription = "Essential tracks by {artist}, generated {date}"
+    maxDescriptionLength                 = 300
 )
 
-var httpClient = &http.Client{Timeout: 10 * time.Second}
+const (
+    PlaylistModeAll        = "all"
+    PlaylistModeEssentials = "essentials"
+)
 
 type CreatePlaylistRequest struct {
     Name      string `json:"name"`
     ArtistURL string `json:"artist_url"`
+    Mode      string `json:"mode"` // "all" (default) or "essentials"
+    Size      int    `json:"size"` // number of tracks in "essentials" mode
+
+    Description   *string `json:"description"` // defaults to the mode's default description
+    Public        bool    `json:"public"`
+    Collaborative bool    `json:"collaborative"` // requires a non-public playlist
+
+    Cover *CoverOptions `json:"cover"` // keeps Spotify's auto-generated mosaic when nil
+
+    DryRun bool `json:"dry_run"` // only report which tracks would be added and check the cover
 }
 
 /* --------- Structs for Spotify API ---------- */
 type CreatePlaylistBody struct {
-    Name   string `json:"name"`
-    Public bool   `json:"public"`
+    Name          string `json:"name"`
+    Description   string `json:"description"`
+    Public        bool   `json:"public"`
+    Collaborative bool   `json:"collaborative"`
 }
 type CreatePlaylistResponse struct {
-    ID   string `json:"id"`
-    URI  string `json:"uri"`
-    Name string `json:"name"`
+    ID         string `json:"id"`
+    URI        string `json:"uri"`
+    Name       string `json:"name"`
+    SnapshotID string `json:"snapshot_id"`
 }
 type AddTracksBody struct {
     URIs []string `json:"uris"`
 }
 type SimplifiedAlbum struct {
-    ID          string `json:"id"`
-    ReleaseDate string `json:"release_date"`
+    ID                   string  `json:"id"`
+    Name                 string  `json:"name"`
+    AlbumType            string  `json:"album_type"`
+    ReleaseDate          string  `json:"release_date"`
+    ReleaseDatePrecision string  `json:"release_date_precision"`
+    Images               []Image `json:"images"`
 }
 type ArtistAlbumsResponse struct {
     Items []SimplifiedAlbum `json:"items"`
     Next  string            `json:"next"`
 }
+type Artist struct {
+    ID     string  `json:"id"`
+    Name   string  `json:"name"`
+    Images []Image `json:"images"`
+}
 type TrackArtist struct {
     ID string `json:"id"`
 }
@@ -83,139 +122,236 @@ func extractArtistID(artistURL string) string {
 }
 
 /* ---------------- MAIN HANDLER ----------------- */
-func CreatePlaylist(c fiber.Ctx) error {
-    userInterface := c.Locals("user")
-    if userInterface == nil {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-            "error": "User not authenticated - no token",
-        })
-    }
-    user, ok := userInterface.(middleware.User)
-    if !ok {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Invalid token data type",
-        })
-    }
-    if user.TOKEN == "" || user.ID == "" {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-            "error": "User not authenticated - empty token",
-        })
+func (h *Handlers) CreatePlaylist(c fiber.Ctx) error {
+    ctx := middleware.Context(c)
+    user, err := middleware.CurrentUser(c)
+    if err != nil {
+        return err
     }
 
     var req CreatePlaylistRequest
     if err := c.Bind().Body(&req); err != nil {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+        return apierr.New(apierr.CodeInvalidRequest, "Invalid request body")
     }
     artistID := extractArtistID(req.ArtistURL)
     if artistID == "" {
-        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid artist URL"})
+        return apierr.New(apierr.CodeInvalidRequest, "Invalid artist URL")
+    }
+    switch req.Mode {
+    case "":
+        req.Mode = PlaylistModeAll
+    case PlaylistModeAll:
+    case PlaylistModeEssentials:
+        if req.Size == 0 {
+            req.Size = essentialsDefaultSize
+        }
+        if req.Size < 1 || req.Size > essentialsMaxSize {
+            return apierr.New(apierr.CodeInvalidRequest, fmt.Sprintf("size must be between 1 and %d", essentialsMaxSize))
+        }
+    default:
+        return apierr.New(apierr.CodeInvalidRequest, "Invalid mode")
+    }
+    if req.Collaborative && req.Public {
+        return apierr.New(apierr.CodeInvalidRequest, "Collaborative playlists cannot be public")
+    }
+
+    // Failures from here on are reported to the user's webhooks
+    playlistID := ""
+    buildFailed := func(err error) error {
+        if !req.DryRun {
+            h.emitWebhookEvent(ctx, user.ID, WebhookEventBuildFailed, BuildEvent{
+                Operation:  OperationCreate,
+                PlaylistID: playlistID,
+                ArtistID:   artistID,
+            }.withError(err))
+        }
+        return err
     }
 
     // 1. Fetch all tracks for the artist (filtered by artist), with album IDs
-    tracks, err := getCachedArtistTracks(artistID, user.TOKEN)
+    tracks, err := h.getCachedArtistTracks(ctx, artistID, user.TOKEN)
     if err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": utils.WithStack(err),
-        })
+        return buildFailed(artistError(utils.WithStack(err), "Failed to fetch artist tracks"))
     }
     if len(tracks) == 0 {
-        return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-            "error": "No tracks found for this artist",
-        })
+        return buildFailed(apierr.New(apierr.CodeNoTracks, "No tracks found for this artist"))
     }
 
-    // 2. Fetch album release dates for sorting
-    albumReleaseDates, err := getArtistAlbumsReleaseDates(artistID, user.TOKEN)
+    // 2. Fetch album metadata for sorting and for describing the track list
+    albums, err := h.getArtistAlbumsByID(ctx, artistID, user.TOKEN)
     if err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": "Failed to get album release dates",
-        })
+        return buildFailed(artistError(err, "Failed to get album release dates"))
     }
 
-    // 3. Compose slice of TrackWithDate to sort by release date
-    sortableTracks := make([]TrackWithDate, 0, len(tracks))
-    for _, t := range tracks {
-        releaseDate := albumReleaseDates[t.AlbumID]
-        sortableTracks = append(sortableTracks, TrackWithDate{
-            Track:       t,
-            ReleaseDate: releaseDate,
-        })
+    // 3. Pick the tracks and their order depending on the mode
+    var ordered []SimplifiedTrack
+    var reasons map[string]string
+    if req.Mode == PlaylistModeEssentials {
+        ordered, reasons, err = h.getEssentialTracks(ctx, artistID, user.TOKEN, tracks, req.Size)
+        if err != nil {
+            return buildFailed(apierr.Wrap(err, apierr.CodeInternal, "Failed to build essentials"))
+        }
+    } else {
+        ordered = sortTracksByReleaseDate(tracks, albums)
     }
 
-    // 4. Sort tracks by release date (oldest first)
-    sort.Slice(sortableTracks, func(i, j int) bool {
-        return sortableTracks[i].ReleaseDate > sortableTracks[j].ReleaseDate
-    })
-
-    // 5. Extract URIs in sorted order
     var uris []string
-    for _, td := range sortableTracks {
-        uris = append(uris, td.Track.URI)
+    for _, t := range ordered {
+        uris = append(uris, t.URI)
+    }
+
+    // 4. Resolve the playlist description
+    description := defaultPlaylistDescription
+    if req.Mode == PlaylistModeEssentials {
+        description = defaultEssentialsPlaylistDescription
+    }
+    if req.Description != nil {
+        description = *req.Description
+    }
+    if strings.Contains(description, "{artist}") {
+        artist, err := h.getArtist(ctx, artistID, user.TOKEN)
+        if err != nil {
+            return buildFailed(artistError(err, "Failed to get artist"))
+        }
+        description = strings.ReplaceAll(description, "{artist}", artist.Name)
+    }
+    description = strings.ReplaceAll(description, "{date}", time.Now().Format("2006-01-02"))
+    // Checked once filled in, a long artist name can push it over
+    if utf8.RuneCountInString(description) > maxDescriptionLength {
+        return buildFailed(apierr.New(apierr.CodeInvalidRequest, fmt.Sprintf("description must be at most %d characters", maxDescriptionLength)))
+    }
+
+    // 5. Prepare the cover image before touching the user's account, so a bad upload fails early,
+    // dry runs included
+    var cover []byte
+    if req.Cover != nil {
+        cover, err = h.buildCoverImage(ctx, req.Cover, artistID, user.TOKEN)
+        if err != nil {
+            return buildFailed(apierr.Wrap(err, apierr.CodeInternal, "Failed to build cover image"))
+        }
+    }
+
+    if req.DryRun {
+        return c.Status(fiber.StatusOK).JSON(fiber.Map{
+            "dry_run":     true,
+            "name":        req.Name,
+            "description": description,
+            "count":       len(uris),
+            "tracks":      describeCreatePlan(tracks, ordered, reasons, albums),
+            "cover":       cover != nil,
+        })
     }
 
     // 6. Create the playlist on user's account
-    playlistID, err := createPlaylist(user.ID, req.Name, user.TOKEN)
+    playlist, err := h.createPlaylist(ctx, user.ID, CreatePlaylistBody{
+        Name:          req.Name,
+        Description:   description,
+        Public:        req.Public,
+        Collaborative: req.Collaborative,
+    }, user.TOKEN)
     if err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-            "error": fmt.Sprintf("Failed to create playlist: %v", err),
-        })
+        return buildFailed(apierr.Wrap(err, apierr.CodeInternal, "Failed to create playlist"))
+    }
+    playlistID = playlist.ID
+    slog.InfoContext(ctx, "playlist created, adding tracks", "playlist_id", playlistID, "name", req.Name)
+
+    // The playlist starts out empty, restoring this snapshot undoes the whole build
+    if _, err := h.recordPlaylistSnapshot(ctx, user.ID, playlistID, OperationCreate, playlist.SnapshotID, nil); err != nil {
+        slog.WarnContext(ctx, "could not record playlist snapshot", "playlist_id", playlistID, "err", err)
     }
-    log.Printf("🎼 Playlist \"%s\" created. Adding songs…", req.Name)
 
     // 7. Add tracks in batches of 100, with progress logs
     const batchSize = 100
+    addCtx, addSpan := tracing.Tracer.Start(ctx, "add tracks", trace.WithAttributes(attribute.Int("tracks", len(uris))))
     for i := 0; i < len(uris); i += batchSize {
         end := i + batchSize
         if end > len(uris) {
             end = len(uris)
         }
         batch := uris[i:end]
-        if err := addTracksToPlaylist(playlistID, batch, user.TOKEN); err != nil {
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "error": fmt.Sprintf("Failed to add tracks: %v", err),
-            })
+        if err := h.addTracksToPlaylist(addCtx, playlistID, batch, user.TOKEN); err != nil {
+            tracing.End(addSpan, err)
+            return buildFailed(apierr.Wrap(err, apierr.CodeInternal, "Failed to add tracks"))
         }
         for j := i; j < end; j++ {
-            log.Printf("🟢 Added: %s\n", sortableTracks[j].Track.Name)
+            slog.DebugContext(ctx, "added track", "playlist_id", playlistID, "track", ordered[j].Name)
         }
     }
+    addSpan.End()
 
+    // 8. Set the cover last; the playlist is usable even if this fails
+    coverUpdated := false
+    if cover != nil {
+        if err := h.uploadPlaylistCover(ctx, playlistID, cover, user.TOKEN); err != nil {
+            slog.WarnContext(ctx, "could not set playlist cover", "playlist_id", playlistID, "err", err)
+        } else {
+            coverUpdated = true
+        }
+    }
+
+    h.emitWebhookEvent(ctx, user.ID, WebhookEventBuildCompleted, BuildEvent{
+        Operation:  OperationCreate,
+        PlaylistID: playlistID,
+        ArtistID:   artistID,
+        AddedCount: len(uris),
+    })
     return c.Status(fiber.StatusOK).JSON(fiber.Map{
-        "message":  fmt.Sprintf("Playlist '%s' created and %d tracks added.", req.Name, len(uris)),
-        "playlist": playlistID,
-        "count":    len(uris),
+        "message":       fmt.Sprintf("Playlist '%s' created and %d tracks added.", req.Name, len(uris)),
+        "playlist":      playlistID,
+        "count":         len(uris),
+        "cover_updated": coverUpdated,
     })
 }
 
 /* -------------- Helper functions -------------- */
 
-func getArtistAlbumsReleaseDates(artistID, token string) (map[string]string, error) {
-    albumReleaseDates := make(map[string]string)
-    nextURL := fmt.Sprintf("%s/v1/artists/%s/albums?include_groups=album,single&limit=50", spotifyApiURL, artistID)
-    for nextURL != "" {
-        var albumsResponse ArtistAlbumsResponse
-        err := makeAPIRequest(nextURL, token, &albumsResponse)
-        if err != nil {
-            return nil, err
-        }
-        for _, album := range albumsResponse.Items {
-            albumReleaseDates[album.ID] = album.ReleaseDate
-        }
-        nextURL = albumsResponse.Next
+// sortTracksByReleaseDate orders tracks by the release date of their album, newest first.
+func sortTracksByReleaseDate(tracks []SimplifiedTrack, albums map[string]SimplifiedAlbum) []SimplifiedTrack {
+    sortableTracks := make([]TrackWithDate, 0, len(tracks))
+    for _, t := range tracks {
+        sortableTracks = append(sortableTracks, TrackWithDate{
+            Track:       t,
+            ReleaseDate: albums[t.AlbumID].ReleaseDate,
+        })
     }
-    return albumReleaseDates, nil
+    sort.Slice(sortableTracks, func(i, j int) bool {
+        return sortableTracks[i].ReleaseDate > sortableTracks[j].ReleaseDate
+    })
+
+    sorted := make([]SimplifiedTrack, 0, len(sortableTracks))
+    for _, td := range sortableTracks {
+        sorted = append(sorted, td.Track)
+    }
+    return sorted
 }
 
-func getAllArtistTracksWithAlbumID(artistID, token string) ([]SimplifiedTrack, error) {
-    albumIDs, err := getArtistAlbumIDs(artistID, token)
+func (h *Handlers) getArtistAlbumsByID(ctx context.Context, artistID, token string) (map[string]SimplifiedAlbum, error) {
+    albums, err := h.getCachedArtistAlbums(ctx, artistID, token)
+    if err != nil {
+        return nil, err
+    }
+    albumsByID := make(map[string]SimplifiedAlbum, len(albums))
+    for _, album := range albums {
+        albumsByID[album.ID] = album
+    }
+    return albumsByID, nil
+}
+
+func (h *Handlers) getAllArtistTracksWithAlbumID(ctx context.Context, artistID, token string) (result []SimplifiedTrack, err error) {
+    ctx, span := tracing.Tracer.Start(ctx, "crawl artist", trace.WithAttributes(attribute.String("artist.id", artistID)))
+    defer func() { tracing.End(span, err) }()
+
+    albums, err := h.getCachedArtistAlbums(ctx, artistID, token)
     if err != nil {
         return nil, err
     }
     uniqueTracks := make(map[string]SimplifiedTrack)
-    for _, albumID := range albumIDs {
-        tracks, err := getAlbumTracksWithAlbumID(albumID, token, artistID)
+    failed := make(map[string]struct{})
+    for _, album := range albums {
+        tracks, err := h.getAlbumTracksWithAlbumID(ctx, album.ID, token, artistID)
         if err != nil {
-            log.Printf("⚠️ Could not fetch tracks for album %s: %v", albumID, err)
+            slog.WarnContext(ctx, "could not fetch album tracks", "album_id", album.ID, "err", err)
+            failed[album.ID] = struct{}{}
             continue
         }
         for _, track := range tracks {
@@ -224,173 +360,149 @@ func getAllArtistTracksWithAlbumID(artistID, token string) ([]SimplifiedTrack, e
             }
         }
     }
-    result := make([]SimplifiedTrack, 0, len(uniqueTracks))
+    result = make([]SimplifiedTrack, 0, len(uniqueTracks))
     for _, track := range uniqueTracks {
         result = append(result, track)
     }
+    span.SetAttributes(attribute.Int("albums", len(albums)), attribute.Int("albums.failed", len(failed)), attribute.Int("tracks", len(result)))
+
+    // Compare with the previous crawl to pick up new releases
+    if _, err := h.recordArtistDiscography(ctx, artistID, albums, result, failed); err != nil {
+        slog.WarnContext(ctx, "could not record artist discography", "artist_id", artistID, "err", err)
+    }
     return result, nil
 }
 
-func getArtistAlbumIDs(artistID, token string) ([]string, error) {
-    var albumIDs []string
-    nextURL := fmt.Sprintf("%s/v1/artists/%s/albums?include_groups=album,single&limit=50", spotifyApiURL, artistID)
+func (h *Handlers) getArtistAlbums(ctx context.Context, artistID, token string) ([]SimplifiedAlbum, error) {
+    var albums []SimplifiedAlbum
+    nextURL := fmt.Sprintf("%s/v1/artists/%s/albums?include_groups=%s&limit=50", spotifyApiURL, artistID, artistAlbumGroups)
     for nextURL != "" {
         var albumsResponse ArtistAlbumsResponse
-        err := makeAPIRequest(nextURL, token, &albumsResponse)
+        err := h.makeAPIRequest(ctx, nextURL, token, &albumsResponse)
         if err != nil {
             return nil, err
         }
-        for _, album := range albumsResponse.Items {
-            albumIDs = append(albumIDs, album.ID)
-        }
+        albums = append(albums, albumsResponse.Items...)
         nextURL = albumsResponse.Next
     }
-    return albumIDs, nil
+    return albums, nil
+}
+
+func (h *Handlers) getAlbumTracksWithAlbumID(ctx context.Context, albumID, token, targetArtistID string) ([]SimplifiedTrack, error) {
+    albumTracks, err := h.getCachedAlbumTracks(ctx, albumID, token)
+    if err != nil {
+        return nil, err
+    }
+    var tracks []SimplifiedTrack
+    for _, track := range albumTracks {
+        // Include track only if targetArtistID exists in track.Artists
+        containsArtist := false
+        for _, artist := range track.Artists {
+            if artist.ID == targetArtistID {
+                containsArtist = true
+                break
+            }
+        }
+        if containsArtist {
+            tracks = append(tracks, track)
+        }
+    }
+    return tracks, nil
 }
 
-func getAlbumTracksWithAlbumID(albumID, token, targetArtistID string) ([]SimplifiedTrack, error) {
+// getAlbumTracks returns every track on the album, whoever the artist
+func (h *Handlers) getAlbumTracks(ctx context.Context, albumID, token string) ([]SimplifiedTrack, error) {
     var tracks []SimplifiedTrack
     nextURL := fmt.Sprintf("%s/v1/albums/%s/tracks?limit=50", spotifyApiURL, albumID)
 
     for nextURL != "" {
         var tracksResponse AlbumTracksResponse
-        err := makeAPIRequest(nextURL, token, &tracksResponse)
+        err := h.makeAPIRequest(ctx, nextURL, token, &tracksResponse)
         if err != nil {
             return nil, err
         }
         for _, track := range tracksResponse.Items {
             track.AlbumID = albumID
-
-            // Include track only if targetArtistID exists in track.Artists
-            containsArtist := false
-            for _, artist := range track.Artists {
-                if artist.ID == targetArtistID {
-                    containsArtist = true
-                    break
-                }
-            }
-            if containsArtist {
-                tracks = append(tracks, track)
-            }
+            tracks = append(tracks, track)
         }
         nextURL = tracksResponse.Next
     }
     return tracks, nil
 }
 
-func createPlaylist(userID string, name string, token string) (string, error) {
+func (h *Handlers) getArtist(ctx context.Context, artistID, token string) (*Artist, error) {
+    var artist Artist
+    url := fmt.Sprintf("%s/v1/artists/%s", spotifyApiURL, artistID)
+    if err := h.makeAPIRequest(ctx, url, token, &artist); err != nil {
+        return nil, err
+    }
+    return &artist, nil
+}
+
+func (h *Handlers) createPlaylist(ctx context.Context, userID string, body CreatePlaylistBody, token string) (*CreatePlaylistResponse, error) {
     url := fmt.Sprintf("%s/v1/users/%s/playlists", spotifyApiURL, userID)
-    body := CreatePlaylistBody{Name: name, Public: false}
     b, _ := json.Marshal(body)
 
-    req, err := http.NewRequest("POST", url, strings.NewReader(string(b)))
+    req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(b)))
     if err != nil {
-        return "", err
+        return nil, err
     }
     req.Header.Set("Authorization", "Bearer "+token)
     req.Header.Set("Content-Type", "application/json")
 
-    resp, err := httpClient.Do(req)
+    resp, err := h.httpClient.Do(req)
     if err != nil {
-        return "", err
+        return nil, err
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusCreated {
-        raw, _ := io.ReadAll(resp.Body)
-        return "", fmt.Errorf("failed to create playlist: %s", string(raw))
+        return nil, apierr.FromSpotify(resp)
     }
     var playlistRes CreatePlaylistResponse
     if err := json.NewDecoder(resp.Body).Decode(&playlistRes); err != nil {
-        return "", err
+        return nil, err
     }
-    return playlistRes.ID, nil
+    return &playlistRes, nil
 }
 
-func addTracksToPlaylist(playlistID string, uris []string, token string) error {
+func (h *Handlers) addTracksToPlaylist(ctx context.Context, playlistID string, uris []string, token string) error {
     url := fmt.Sprintf("%s/v1/playlists/%s/tracks", spotifyApiURL, playlistID)
     body := AddTracksBody{URIs: uris}
     b, _ := json.Marshal(body)
 
-    req, err := http.NewRequest("POST", url, strings.NewReader(string(b)))
+    req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(b)))
     if err != nil {
         return err
     }
     req.Header.Set("Authorization", "Bearer "+token)
     req.Header.Set("Content-Type", "application/json")
 
-    resp, err := httpClient.Do(req)
+    resp, err := h.httpClient.Do(req)
     if err != nil {
         return err
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-        raw, _ := io.ReadAll(resp.Body)
-        return fmt.Errorf("failed to add tracks: %s", string(raw))
+        return apierr.FromSpotify(resp)
     }
     return nil
 }
 
-func makeAPIRequest(url, token string, target interface{}) error {
-    req, err := http.NewRequest("GET", url, nil)
+func (h *Handlers) makeAPIRequest(ctx context.Context, url, token string, target interface{}) error {
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
     if err != nil {
         return err
     }
     req.Header.Set("Authorization", "Bearer "+token)
-    resp, err := httpClient.Do(req)
+    resp, err := h.httpClient.Do(req)
     if err != nil {
         return err
     }
     defer resp.Body.Close()
     if resp.StatusCode != http.StatusOK {
-        bodyBytes, _ := io.ReadAll(resp.Body)
-        return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+        return apierr.FromSpotify(resp)
     }
     return json.NewDecoder(resp.Body).Decode(target)
 }
-
-
-// Caches as JSON under key "artist_tracks:{artistID}" with a TTL (e.g., 6h).
-func getCachedArtistTracks(artistID, token string) ([]SimplifiedTrack, error) {
-    ctx := context.Background()
-    cacheKey := fmt.Sprintf("artist_tracks:%s", artistID)
-    client := config.RedisClient
-
-    // 1. Try to read from cache.
-    result, err := client.Get(ctx, cacheKey).Result()
-    if err == nil {
-        var tracks []SimplifiedTrack
-        if err := json.Unmarshal([]byte(result), &tracks); err == nil {
-            log.Printf("🔍 Redis cache hit for artist %s (%d tracks)", artistID, len(tracks))
-            return tracks, nil
-        }
-        // If decode error, fall through and refill cache.
-        log.Printf("⚠️ Redis cache for artist %s is corrupt, refetching", artistID)
-    }
-
-    // 2. Cache miss or decode problem: Fetch from Spotify and cache result
-    log.Printf("🚀 Redis cache miss for artist %s, fetching tracks", artistID)
-    tracks, err := getAllArtistTracksWithAlbumID(artistID, token)
-    if err != nil {
-        return nil, err
-    }
-
-    // Marshal and save to redis with a TTL (e.g. 6 hours)
-    data, err := json.Marshal(tracks)
-    if err == nil {
-        _ = client.Set(ctx, cacheKey, data, 6*time.Hour).Err()
-        log.Printf("💾 Saved %d tracks to Redis for artist %s", len(tracks), artistID)
-    }
-    return tracks, nil
-}
-
-
-func clearArtistCache(artistID string) {
-    ctx := context.Background()
-    cacheKey := fmt.Sprintf("artist_tracks:%s", artistID)
-    if config.RedisClient != nil {
-        config.RedisClient.Del(ctx, cacheKey)
-        log.Printf("❌ Cleared Redis cache for artist %s", artistID)
-    }
-}
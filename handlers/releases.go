@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"app/apierr"
+	"app/middleware"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v3"
+)
+
+const maxReleaseEvents = 100
+
+// maxDiscographyWrites is how often recordArtistDiscography retries when a concurrent crawl of
+// the same artist writes first
+const maxDiscographyWrites = 5
+
+// ArtistDiscography is the album and track set seen the last time an artist was crawled
+type ArtistDiscography struct {
+	Albums    map[string][]string `json:"albums"` // album ID -> IDs of the artist's tracks on it
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// ReleaseEvent is emitted when a crawl finds an album, or tracks on an album, that weren't
+// there the previous time
+type ReleaseEvent struct {
+	ArtistID    string            `json:"artist_id"`
+	AlbumID     string            `json:"album_id"`
+	AlbumName   string            `json:"album_name"`
+	ReleaseDate string            `json:"release_date"`
+	NewAlbum    bool              `json:"new_album"`
+	NewTracks   []SimplifiedTrack `json:"new_tracks"`
+	DetectedAt  time.Time         `json:"detected_at"`
+}
+
+func artistDiscographyKey(artistID string) string {
+	return fmt.Sprintf("artist_discography:%s", artistID)
+}
+
+func artistReleasesKey(artistID string) string {
+	return fmt.Sprintf("artist_releases:%s", artistID)
+}
+
+// getArtistDiscography returns nil if the artist has never been crawled
+func (h *Handlers) getArtistDiscography(ctx context.Context, artistID string) (*ArtistDiscography, error) {
+	return readArtistDiscography(ctx, h.redis, artistID)
+}
+
+func readArtistDiscography(ctx context.Context, rdb redis.Cmdable, artistID string) (*ArtistDiscography, error) {
+	data, err := rdb.Get(ctx, artistDiscographyKey(artistID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var discography ArtistDiscography
+	if err := json.Unmarshal(data, &discography); err != nil {
+		return nil, err
+	}
+	return &discography, nil
+}
+
+// recordArtistDiscography diffs a fresh crawl against the previous one, stores it, and records
+// a ReleaseEvent for every new album or album with new tracks. Albums whose tracks could not be
+// fetched are listed in failed; their previous entry is kept so they don't show up as new next
+// time. The first crawl of an artist only sets the baseline.
+//
+// The diff and write happen under WATCH, so when crawls of the same artist overlap only the first
+// to write records the events; the others diff again against what it stored.
+func (h *Handlers) recordArtistDiscography(ctx context.Context, artistID string, albums []SimplifiedAlbum, tracks []SimplifiedTrack, failed map[string]struct{}) ([]ReleaseEvent, error) {
+	key := artistDiscographyKey(artistID)
+	for attempt := 0; attempt < maxDiscographyWrites; attempt++ {
+		var events []ReleaseEvent
+		err := h.redis.Watch(ctx, func(tx *redis.Tx) error {
+			previous, err := readArtistDiscography(ctx, tx, artistID)
+			if err != nil {
+				return err
+			}
+			var current *ArtistDiscography
+			current, events = diffDiscography(artistID, previous, albums, tracks, failed)
+			data, err := json.Marshal(current)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 0)
+				for _, event := range events {
+					eventData, err := json.Marshal(event)
+					if err != nil {
+						return err
+					}
+					pipe.LPush(ctx, artistReleasesKey(artistID), eventData)
+				}
+				pipe.LTrim(ctx, artistReleasesKey(artistID), 0, maxReleaseEvents-1)
+				return nil
+			})
+			return err
+		}, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			slog.InfoContext(ctx, "new release", "artist_id", artistID, "album", event.AlbumName, "new_tracks", len(event.NewTracks))
+		}
+		return events, nil
+	}
+	return nil, fmt.Errorf("discography of artist %s kept changing during %d writes", artistID, maxDiscographyWrites)
+}
+
+// diffDiscography builds the discography of a crawl and the events it yields against previous
+func diffDiscography(artistID string, previous *ArtistDiscography, albums []SimplifiedAlbum, tracks []SimplifiedTrack, failed map[string]struct{}) (*ArtistDiscography, []ReleaseEvent) {
+	current := &ArtistDiscography{Albums: make(map[string][]string), UpdatedAt: time.Now().UTC()}
+	albumTracks := make(map[string][]SimplifiedTrack)
+	for _, t := range tracks {
+		albumTracks[t.AlbumID] = append(albumTracks[t.AlbumID], t)
+	}
+
+	var events []ReleaseEvent
+	for _, album := range albums {
+		if _, found := failed[album.ID]; found {
+			if previous != nil {
+				if ids, found := previous.Albums[album.ID]; found {
+					current.Albums[album.ID] = ids
+				}
+			}
+			continue
+		}
+		ids := make([]string, 0, len(albumTracks[album.ID]))
+		for _, t := range albumTracks[album.ID] {
+			ids = append(ids, t.ID)
+		}
+		current.Albums[album.ID] = ids
+		if previous == nil {
+			continue
+		}
+
+		previousIDs, known := previous.Albums[album.ID]
+		seen := make(map[string]struct{}, len(previousIDs))
+		for _, id := range previousIDs {
+			seen[id] = struct{}{}
+		}
+		var newTracks []SimplifiedTrack
+		for _, t := range albumTracks[album.ID] {
+			if _, found := seen[t.ID]; !found {
+				newTracks = append(newTracks, t)
+			}
+		}
+		if known && len(newTracks) == 0 {
+			continue
+		}
+		events = append(events, ReleaseEvent{
+			ArtistID:    artistID,
+			AlbumID:     album.ID,
+			AlbumName:   album.Name,
+			ReleaseDate: album.ReleaseDate,
+			NewAlbum:    !known,
+			NewTracks:   newTracks,
+			DetectedAt:  current.UpdatedAt,
+		})
+	}
+	return current, events
+}
+
+// getArtistReleases returns the recorded release events, newest first
+func (h *Handlers) getArtistReleases(ctx context.Context, artistID string) ([]ReleaseEvent, error) {
+	entries, err := h.redis.LRange(ctx, artistReleasesKey(artistID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]ReleaseEvent, 0, len(entries))
+	for _, entry := range entries {
+		var event ReleaseEvent
+		if err := json.Unmarshal([]byte(entry), &event); err != nil {
+			slog.WarnContext(ctx, "skipping corrupt release event", "artist_id", artistID, "err", err)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// refreshArtistReleases recrawls the artist if the album list has changed since the last crawl,
+// which records any new releases. Listing albums is cheap compared to a full crawl.
+func (h *Handlers) refreshArtistReleases(ctx context.Context, artistID, token string) error {
+	previous, err := h.getArtistDiscography(ctx, artistID)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		albums, err := h.getArtistAlbums(ctx, artistID, token)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, album := range albums {
+			if _, found := previous.Albums[album.ID]; !found {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return nil
+		}
+	}
+	h.clearArtistCache(ctx, artistID)
+	_, err = h.getCachedArtistTracks(ctx, artistID, token)
+	return err
+}
+
+func (h *Handlers) GetArtistReleases(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	events, err := h.getArtistReleases(ctx, c.Params("id"))
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to load releases")
+	}
+	return c.Status(fiber.StatusOK).JSON(events)
+}
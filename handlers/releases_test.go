@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffDiscography(t *testing.T) {
+	albums := []SimplifiedAlbum{{ID: "old"}, {ID: "grown"}, {ID: "new"}, {ID: "failed"}}
+	tracks := []SimplifiedTrack{
+		{ID: "o1", AlbumID: "old"},
+		{ID: "g1", AlbumID: "grown"}, {ID: "g2", AlbumID: "grown"},
+		{ID: "n1", AlbumID: "new"},
+	}
+	failed := map[string]struct{}{"failed": {}}
+	previous := &ArtistDiscography{Albums: map[string][]string{
+		"old":    {"o1"},
+		"grown":  {"g1"},
+		"failed": {"f1"},
+	}}
+
+	type event struct {
+		album     string
+		newAlbum  bool
+		newTracks []string
+	}
+	tests := []struct {
+		name     string
+		previous *ArtistDiscography
+		want     []event
+	}{
+		{"first crawl sets the baseline", nil, nil},
+		{"new album and new tracks", previous, []event{{"grown", false, []string{"g2"}}, {"new", true, []string{"n1"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, events := diffDiscography("artist", tt.previous, albums, tracks, failed)
+
+			var got []event
+			for _, e := range events {
+				var ids []string
+				for _, track := range e.NewTracks {
+					ids = append(ids, track.ID)
+				}
+				got = append(got, event{e.AlbumID, e.NewAlbum, ids})
+			}
+			if !slices.EqualFunc(got, tt.want, func(a, b event) bool {
+				return a.album == b.album && a.newAlbum == b.newAlbum && slices.Equal(a.newTracks, b.newTracks)
+			}) {
+				t.Errorf("events = %+v, want %+v", got, tt.want)
+			}
+
+			// A failed album keeps its previous tracks, so it isn't new next time
+			wantFailed, known := []string(nil), false
+			if tt.previous != nil {
+				wantFailed, known = tt.previous.Albums["failed"]
+			}
+			if gotFailed, found := current.Albums["failed"]; found != known || !slices.Equal(gotFailed, wantFailed) {
+				t.Errorf("failed album = %v (found %v), want %v (found %v)", gotFailed, found, wantFailed, known)
+			}
+
+			// Diffing against the stored result finds nothing, which is what a crawl that lost
+			// the race to write does
+			if _, again := diffDiscography("artist", current, albums, tracks, failed); len(again) != 0 {
+				t.Errorf("diff against itself found %d events", len(again))
+			}
+		})
+	}
+}
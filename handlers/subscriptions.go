@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"app/apierr"
+	"app/middleware"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	subscriptionsKey        = "subscriptions"
+	schedulerLockKey        = "subscriptions:scheduler_lock"
+	defaultSubscriptionMode = ModifyModeAdd
+
+	// A refresh token is kept this long after login, long enough to create a subscription with
+	// the access token from the same login. It is kept while the user has subscriptions.
+	loginRefreshTokenTTL = time.Hour
+
+	// OperationSubscription marks webhook events from automatic subscription updates
+	OperationSubscription = "subscription"
+)
+
+var errNoToken = errors.New("could not get an access token for the user")
+
+// Subscription keeps a playlist up to date with an artist's releases
+type Subscription struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	PlaylistID string     `json:"playlist_id"`
+	ArtistID   string     `json:"artist_id"`
+	Mode       string     `json:"mode"`  // "add" or "sync"
+	Order      string     `json:"order"` // same as ModifyPlaylistRequest.Order
+	CreatedAt  time.Time  `json:"created_at"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+
+	// Release events detected up to this time have been applied
+	ReleasesSeenAt time.Time `json:"releases_seen_at"`
+}
+
+type CreateSubscriptionRequest struct {
+	PlaylistID string `json:"playlist_id"`
+	ArtistURL  string `json:"artist_url"`
+	Mode       string `json:"mode"`
+	Order      string `json:"order"`
+}
+
+func subscriptionKey(id string) string {
+	return fmt.Sprintf("subscription:%s", id)
+}
+
+func userSubscriptionsKey(userID string) string {
+	return fmt.Sprintf("user_subscriptions:%s", userID)
+}
+
+func refreshTokenKey(userID string) string {
+	return fmt.Sprintf("refresh_token:%s", userID)
+}
+
+/* ------------------ Tokens ------------------ */
+
+// storeRefreshToken saves the refresh token under the ID of the user the access token belongs to.
+// It expires after loginRefreshTokenTTL unless the user has subscriptions.
+func (h *Handlers) storeRefreshToken(ctx context.Context, accessToken, refreshToken string) error {
+	var me struct {
+		ID string `json:"id"`
+	}
+	if err := h.makeAPIRequest(ctx, spotifyApiURL+"/v1/me", accessToken, &me); err != nil {
+		return err
+	}
+	subscriptions, err := h.redis.SCard(ctx, userSubscriptionsKey(me.ID)).Result()
+	if err != nil {
+		return err
+	}
+	ttl := loginRefreshTokenTTL
+	if subscriptions > 0 {
+		ttl = 0
+	}
+	return h.redis.Set(ctx, refreshTokenKey(me.ID), refreshToken, ttl).Err()
+}
+
+// getUserAccessToken gets a fresh access token for a user from their stored refresh token
+func (h *Handlers) getUserAccessToken(ctx context.Context, userID string) (string, error) {
+	refreshToken, err := h.redis.Get(ctx, refreshTokenKey(userID)).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("no refresh token stored for user %s, they need to log in again", userID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", refreshToken)
+	token, err := h.requestSpotifyToken(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	// Spotify may rotate the refresh token. XX, so a token deleted with the last subscription in
+	// the meantime stays deleted.
+	if token.RefreshToken != "" && token.RefreshToken != refreshToken {
+		err := h.redis.SetArgs(ctx, refreshTokenKey(userID), token.RefreshToken, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+		if err != nil && err != redis.Nil {
+			slog.WarnContext(ctx, "could not store rotated refresh token", "user_id", userID, "err", err)
+		}
+	}
+	return token.AccessToken, nil
+}
+
+/* ------------------ Storage ------------------ */
+
+// saveSubscription stores a new subscription and keeps the user's refresh token while it exists
+func (h *Handlers) saveSubscription(ctx context.Context, sub *Subscription) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	pipe := h.redis.TxPipeline()
+	pipe.Set(ctx, subscriptionKey(sub.ID), data, 0)
+	pipe.SAdd(ctx, subscriptionsKey, sub.ID)
+	pipe.SAdd(ctx, userSubscriptionsKey(sub.UserID), sub.ID)
+	pipe.Persist(ctx, refreshTokenKey(sub.UserID))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// updateSubscription writes back a subscription only if it still exists, so one deleted while
+// the scheduler was checking it is not recreated. It reports whether it was written.
+func (h *Handlers) updateSubscription(ctx context.Context, sub *Subscription) (bool, error) {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return false, err
+	}
+	return h.redis.SetXX(ctx, subscriptionKey(sub.ID), data, 0).Result()
+}
+
+func (h *Handlers) getSubscription(ctx context.Context, id string) (*Subscription, error) {
+	data, err := h.redis.Get(ctx, subscriptionKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var sub Subscription
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (h *Handlers) getSubscriptions(ctx context.Context, setKey string) ([]Subscription, error) {
+	ids, err := h.redis.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]Subscription, 0, len(ids))
+	for _, id := range ids {
+		sub, err := h.getSubscription(ctx, id)
+		if err != nil {
+			slog.WarnContext(ctx, "could not load subscription", "subscription_id", id, "err", err)
+			continue
+		}
+		subs = append(subs, *sub)
+	}
+	return subs, nil
+}
+
+// deleteSubscriptionScript removes a subscription, and the user's refresh token along with
+// their last subscription. It runs as a script so a subscription created at the same time
+// can't lose its token.
+var deleteSubscriptionScript = redis.NewScript(`
+redis.call("DEL", KEYS[1])
+redis.call("SREM", KEYS[2], ARGV[1])
+redis.call("SREM", KEYS[3], ARGV[1])
+if redis.call("SCARD", KEYS[3]) == 0 then
+	redis.call("DEL", KEYS[4])
+end
+return 1
+`)
+
+func (h *Handlers) deleteSubscription(ctx context.Context, sub *Subscription) error {
+	keys := []string{subscriptionKey(sub.ID), subscriptionsKey, userSubscriptionsKey(sub.UserID), refreshTokenKey(sub.UserID)}
+	return deleteSubscriptionScript.Run(ctx, h.redis, keys, sub.ID).Err()
+}
+
+/* ------------------ Handlers ------------------ */
+
+func (h *Handlers) CreateSubscription(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req CreateSubscriptionRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return apierr.New(apierr.CodeInvalidRequest, "Invalid request body")
+	}
+	if req.PlaylistID == "" || req.ArtistURL == "" {
+		return apierr.New(apierr.CodeInvalidRequest, "playlist_id and artist_url are required")
+	}
+	if req.Mode == "" {
+		req.Mode = defaultSubscriptionMode
+	}
+	if req.Mode != ModifyModeAdd && req.Mode != ModifyModeSync {
+		return apierr.New(apierr.CodeInvalidRequest, "Invalid mode")
+	}
+	if req.Order != "" && req.Order != PlaylistOrderReleaseDate {
+		return apierr.New(apierr.CodeInvalidRequest, "Invalid order")
+	}
+	artistID := extractArtistID(req.ArtistURL)
+	if artistID == "" {
+		return apierr.New(apierr.CodeInvalidRequest, "Invalid artist URL")
+	}
+
+	// Updates need a refresh token, which is only stored on login
+	exists, err := h.redis.Exists(ctx, refreshTokenKey(user.ID)).Result()
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to check refresh token")
+	}
+	if exists == 0 {
+		return apierr.New(apierr.CodeRefreshTokenMissing, "No refresh token stored, log in again to enable subscriptions")
+	}
+
+	// Releases are detected against the last crawl, so make sure there is one
+	if err := h.refreshArtistReleases(ctx, artistID, user.TOKEN); err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to get artist albums")
+	}
+
+	// Only releases after this point trigger an update
+	now := time.Now().UTC()
+	sub := &Subscription{
+		ID:             strconv.FormatInt(now.UnixNano(), 36),
+		UserID:         user.ID,
+		PlaylistID:     req.PlaylistID,
+		ArtistID:       artistID,
+		Mode:           req.Mode,
+		Order:          req.Order,
+		CreatedAt:      now,
+		ReleasesSeenAt: now,
+	}
+	if err := h.saveSubscription(ctx, sub); err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to save subscription")
+	}
+	return c.Status(fiber.StatusCreated).JSON(sub)
+}
+
+func (h *Handlers) GetSubscriptions(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	subs, err := h.getSubscriptions(ctx, userSubscriptionsKey(user.ID))
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to load subscriptions")
+	}
+	return c.Status(fiber.StatusOK).JSON(subs)
+}
+
+func (h *Handlers) DeleteSubscription(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	sub, err := h.getSubscription(ctx, c.Params("id"))
+	if err == redis.Nil || (err == nil && sub.UserID != user.ID) {
+		return apierr.New(apierr.CodeSubscriptionNotFound, "Subscription not found")
+	}
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to load subscription")
+	}
+	if err := h.deleteSubscription(ctx, sub); err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to delete subscription")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Subscription deleted"})
+}
+
+/* ------------------ Scheduler ------------------ */
+
+// StartSubscriptionScheduler checks all subscriptions for new releases every interval, until
+// shutdown starts
+func (h *Handlers) StartSubscriptionScheduler(interval time.Duration) {
+	h.jobs.run(context.Background(), jobSubscriptionScheduler, func(ctx context.Context) { h.runSubscriptionScheduler(ctx, interval) })
+}
+
+func (h *Handlers) runSubscriptionScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.jobs.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// With several replicas running, only one of them does the check. The lock lasts nearly
+		// the whole interval, so replicas whose tickers are out of phase still find it held; it
+		// expires just before the next tick of the replica that took it.
+		acquired, err := h.redis.SetNX(ctx, schedulerLockKey, "1", interval*9/10).Result()
+		if err != nil {
+			slog.WarnContext(ctx, "subscription scheduler could not take lock", "err", err)
+			continue
+		}
+		if acquired {
+			h.checkSubscriptions(ctx)
+		}
+	}
+}
+
+// checkSubscriptions saves each subscription as it is checked, so if shutdown starts midway the
+// rest are simply checked on the next run. Subscriptions are loaded one at a time, a run can
+// take minutes and users may delete them meanwhile.
+func (h *Handlers) checkSubscriptions(ctx context.Context) {
+	ids, err := h.redis.SMembers(ctx, subscriptionsKey).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "could not load subscriptions", "err", err)
+		return
+	}
+	slog.InfoContext(ctx, "checking subscriptions for new releases", "subscriptions", len(ids))
+
+	tokens := make(map[string]string)
+	refreshed := make(map[string]struct{})
+	for i, id := range ids {
+		if h.jobs.stopping() {
+			slog.InfoContext(ctx, "subscription check stopped for shutdown", "remaining", len(ids)-i)
+			return
+		}
+		sub, err := h.getSubscription(ctx, id)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "could not load subscription", "subscription_id", id, "err", err)
+			continue
+		}
+		token, found := tokens[sub.UserID]
+		if !found {
+			token, err = h.getUserAccessToken(ctx, sub.UserID)
+			if err != nil {
+				slog.WarnContext(ctx, "could not refresh user token", "user_id", sub.UserID, "err", err)
+			}
+			tokens[sub.UserID] = token
+		}
+
+		err = errNoToken
+		if token != "" {
+			// Every artist is recrawled at most once per run, whoever subscribed to it
+			if _, found := refreshed[sub.ArtistID]; !found {
+				err = h.refreshArtistReleases(ctx, sub.ArtistID, token)
+				if err == nil {
+					refreshed[sub.ArtistID] = struct{}{}
+				}
+			}
+			if _, found := refreshed[sub.ArtistID]; found {
+				err = h.checkSubscription(ctx, sub, token)
+			}
+		}
+		now := time.Now().UTC()
+		sub.CheckedAt = &now
+		sub.LastError = ""
+		if err != nil {
+			sub.LastError = err.Error()
+			slog.WarnContext(ctx, "subscription check failed", "subscription_id", sub.ID, "err", err)
+		}
+		if saved, err := h.updateSubscription(ctx, sub); err != nil {
+			slog.WarnContext(ctx, "could not save subscription", "subscription_id", sub.ID, "err", err)
+		} else if !saved {
+			slog.InfoContext(ctx, "subscription was deleted during the check", "subscription_id", sub.ID)
+		}
+	}
+}
+
+// checkSubscription updates the playlist if the artist has releases the subscription hasn't
+// applied yet
+func (h *Handlers) checkSubscription(ctx context.Context, sub *Subscription, token string) error {
+	events, err := h.getArtistReleases(ctx, sub.ArtistID)
+	if err != nil {
+		return err
+	}
+	latest := sub.ReleasesSeenAt
+	pending := 0
+	for _, event := range events {
+		if event.DetectedAt.After(sub.ReleasesSeenAt) {
+			pending++
+			if event.DetectedAt.After(latest) {
+				latest = event.DetectedAt
+			}
+		}
+	}
+	if pending == 0 {
+		return nil
+	}
+
+	result, err := h.applyModify(ctx, sub.UserID, token, sub.ArtistID, ModifyPlaylistRequest{
+		PlaylistID: sub.PlaylistID,
+		Mode:       sub.Mode,
+		Order:      sub.Order,
+	})
+	if err != nil {
+		h.emitWebhookEvent(ctx, sub.UserID, WebhookEventBuildFailed, BuildEvent{
+			Operation:  OperationSubscription,
+			PlaylistID: sub.PlaylistID,
+			ArtistID:   sub.ArtistID,
+		}.withError(err))
+		return err
+	}
+	slog.InfoContext(ctx, "subscription applied new releases", "subscription_id", sub.ID, "releases", pending, "result", result.Message)
+	h.emitWebhookEvent(ctx, sub.UserID, WebhookEventSubscriptionUpdate, BuildEvent{
+		Operation:    OperationSubscription,
+		PlaylistID:   sub.PlaylistID,
+		ArtistID:     sub.ArtistID,
+		AddedCount:   result.AddedCount,
+		RemovedCount: result.RemovedCount,
+	})
+
+	now := time.Now().UTC()
+	sub.UpdatedAt = &now
+	sub.ReleasesSeenAt = latest
+	return nil
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"app/metrics"
+	"app/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spotifyTransport observes every Spotify call made through httpClient, image downloads included
+type spotifyTransport struct {
+	base http.RoundTripper
+	// reachableAt is set to the unix nano time of every response
+	reachableAt *atomic.Int64
+}
+
+func (t *spotifyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := metrics.SpotifyEndpoint(req.URL)
+	ctx, span := tracing.Tracer.Start(req.Context(), "spotify "+req.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Host),
+			semconv.URLPath(req.URL.Path),
+		),
+	)
+	if page, ok := spotifyPage(req.URL); ok {
+		span.SetAttributes(attribute.Int("spotify.page", page))
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	metrics.SpotifyRequestDuration.WithLabelValues(req.Method, endpoint).Observe(time.Since(start).Seconds())
+
+	status := "error"
+	if err == nil {
+		// Any answer, even an error status, means Spotify is reachable
+		t.reachableAt.Store(time.Now().UnixNano())
+		status = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	metrics.SpotifyRequests.WithLabelValues(req.Method, endpoint, status).Inc()
+	tracing.End(span, err)
+	return resp, err
+}
+
+// spotifyPage numbers the pages of a paginated call from 1, using the limit and offset that
+// Spotify puts in its next URLs. ok is false for calls that aren't paginated.
+func spotifyPage(u *url.URL) (page int, ok bool) {
+	query := u.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	return offset/limit + 1, true
+}
+
+// observeSpotify returns a copy of client whose calls go through spotifyTransport
+func observeSpotify(client *http.Client, reachableAt *atomic.Int64) *http.Client {
+	observed := *client
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	observed.Transport = &spotifyTransport{base: base, reachableAt: reachableAt}
+	return &observed
+}
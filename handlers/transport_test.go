@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSpotifyPage(t *testing.T) {
+	tests := []struct {
+		url      string
+		wantPage int
+		wantOK   bool
+	}{
+		{"https://api.spotify.com/v1/artists/abc/albums?limit=50", 1, true},
+		{"https://api.spotify.com/v1/artists/abc/albums?limit=50&offset=0", 1, true},
+		{"https://api.spotify.com/v1/artists/abc/albums?offset=100&limit=50", 3, true},
+		{"https://api.spotify.com/v1/albums/abc/tracks?limit=20&offset=30", 2, true},
+		{"https://api.spotify.com/v1/artists/abc", 0, false},
+		{"https://api.spotify.com/v1/artists/abc/albums?offset=50", 0, false},
+		{"https://api.spotify.com/v1/artists/abc/albums?limit=0", 0, false},
+		{"https://api.spotify.com/v1/artists/abc/albums?limit=abc", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, ok := spotifyPage(u)
+			if page != tt.wantPage || ok != tt.wantOK {
+				t.Errorf("spotifyPage() = %d, %v, want %d, %v", page, ok, tt.wantPage, tt.wantOK)
+			}
+		})
+	}
+}
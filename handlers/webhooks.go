@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"app/apierr"
+	"app/middleware"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	WebhookEventBuildCompleted     = "build.completed"
+	WebhookEventBuildFailed        = "build.failed"
+	WebhookEventSubscriptionUpdate = "subscription.updated"
+	WebhookEventPing               = "ping"
+
+	webhookMaxAttempts   = 5
+	webhookInitialDelay  = 2 * time.Second
+	maxWebhookDeliveries = 100
+
+	WebhookSignatureHeader = "X-Playmaker-Signature"
+	WebhookTimestampHeader = "X-Playmaker-Timestamp"
+	WebhookEventHeader     = "X-Playmaker-Event"
+)
+
+var webhookEvents = []string{WebhookEventBuildCompleted, WebhookEventBuildFailed, WebhookEventSubscriptionUpdate}
+
+// newWebhookClient returns the client webhooks are delivered with. Unless allowPrivate is set it
+// only connects to public addresses. The check runs on the resolved address at dial time, so a
+// host that passed validation can't later be pointed at the internal network through DNS.
+// Redirects are not followed, a 3xx counts as a failed delivery.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = webhookDialControl
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// nonPublicPrefixes are reserved ranges the netip.Addr predicates don't cover
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// isPublicAddr reports whether webhooks may be sent to addr. Loopback, private, link-local
+// (which includes cloud metadata endpoints), multicast and unspecified addresses are refused.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("webhook address %q: %w", address, err)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("webhook address %s is not a public address", addrPort.Addr())
+	}
+	return nil
+}
+
+// validateWebhookURL checks that raw is an absolute http(s) URL whose host resolves, and unless
+// allowPrivate is set, only to public addresses
+func validateWebhookURL(ctx context.Context, raw string, allowPrivate bool) error {
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+		return apierr.New(apierr.CodeInvalidRequest, "url must be an absolute http(s) URL")
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", target.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return &apierr.Error{Code: apierr.CodeInvalidRequest, Message: "url host could not be resolved", Err: err}
+	}
+	if allowPrivate {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return apierr.New(apierr.CodeInvalidRequest, "url must point to a public address")
+		}
+	}
+	return nil
+}
+
+type Webhook struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"` // only returned when the webhook is created
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"` // defaults to all events
+}
+
+// WebhookPayload is the signed JSON body sent to receivers
+type WebhookPayload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// BuildEvent is the payload data for build and subscription events
+type BuildEvent struct {
+	Operation    string      `json:"operation"` // OperationCreate, OperationModify or OperationSubscription
+	PlaylistID   string      `json:"playlist_id,omitempty"`
+	ArtistID     string      `json:"artist_id"`
+	AddedCount   int         `json:"added_count"`
+	RemovedCount int         `json:"removed_count"`
+	Error        string      `json:"error,omitempty"`
+	ErrorCode    apierr.Code `json:"error_code,omitempty"`
+}
+
+// withError fills in the code and message the client got for err
+func (e BuildEvent) withError(err error) BuildEvent {
+	apiErr := apierr.From(err)
+	e.Error = apiErr.Message
+	e.ErrorCode = apiErr.Code
+	return e
+}
+
+// WebhookDelivery is one delivery attempt, kept in the webhook's delivery log
+type WebhookDelivery struct {
+	PayloadID  string    `json:"payload_id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	DurationMS int64     `json:"duration_ms"`
+	AttemptAt  time.Time `json:"attempted_at"`
+}
+
+func webhookKey(id string) string {
+	return fmt.Sprintf("webhook:%s", id)
+}
+
+func userWebhooksKey(userID string) string {
+	return fmt.Sprintf("user_webhooks:%s", userID)
+}
+
+func webhookDeliveriesKey(id string) string {
+	return fmt.Sprintf("webhook_deliveries:%s", id)
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 of "timestamp.body". Receivers recompute it
+// with their secret and compare against the signature header.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/* ------------------ Storage ------------------ */
+
+func (h *Handlers) saveWebhook(ctx context.Context, hook *Webhook) error {
+	data, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	pipe := h.redis.TxPipeline()
+	pipe.Set(ctx, webhookKey(hook.ID), data, 0)
+	pipe.SAdd(ctx, userWebhooksKey(hook.UserID), hook.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (h *Handlers) getWebhook(ctx context.Context, id string) (*Webhook, error) {
+	data, err := h.redis.Get(ctx, webhookKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var hook Webhook
+	if err := json.Unmarshal(data, &hook); err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+func (h *Handlers) getUserWebhooks(ctx context.Context, userID string) ([]Webhook, error) {
+	ids, err := h.redis.SMembers(ctx, userWebhooksKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]Webhook, 0, len(ids))
+	for _, id := range ids {
+		hook, err := h.getWebhook(ctx, id)
+		if err != nil {
+			slog.WarnContext(ctx, "could not load webhook", "webhook_id", id, "err", err)
+			continue
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, nil
+}
+
+func (h *Handlers) deleteWebhook(ctx context.Context, hook *Webhook) error {
+	pipe := h.redis.TxPipeline()
+	pipe.Del(ctx, webhookKey(hook.ID), webhookDeliveriesKey(hook.ID))
+	pipe.SRem(ctx, userWebhooksKey(hook.UserID), hook.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (h *Handlers) logWebhookDelivery(ctx context.Context, hookID string, delivery WebhookDelivery) {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+	pipe := h.redis.TxPipeline()
+	pipe.LPush(ctx, webhookDeliveriesKey(hookID), data)
+	pipe.LTrim(ctx, webhookDeliveriesKey(hookID), 0, maxWebhookDeliveries-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "could not log webhook delivery", "webhook_id", hookID, "err", err)
+	}
+}
+
+/* ------------------ Delivery ------------------ */
+
+// emitWebhookEvent sends event to every webhook of the user subscribed to it. Deliveries run in
+// the background and never block the caller.
+func (h *Handlers) emitWebhookEvent(ctx context.Context, userID, event string, data any) {
+	hooks, err := h.getUserWebhooks(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "could not load user webhooks", "user_id", userID, "err", err)
+		return
+	}
+	for _, hook := range hooks {
+		if slices.Contains(hook.Events, event) {
+			payload := newWebhookPayload(event, data)
+			h.jobs.run(ctx, jobWebhookDelivery, func(ctx context.Context) { h.deliverWebhook(ctx, hook, payload) })
+		}
+	}
+}
+
+func newWebhookPayload(event string, data any) WebhookPayload {
+	now := time.Now().UTC()
+	return WebhookPayload{
+		ID:        strconv.FormatInt(now.UnixNano(), 36),
+		Event:     event,
+		CreatedAt: now,
+		Data:      data,
+	}
+}
+
+// deliverWebhook posts the payload, retrying with exponential backoff until the receiver
+// answers with a 2xx status or webhookMaxAttempts is reached. Retries stop when shutdown starts;
+// the attempts made so far are in the delivery log.
+func (h *Handlers) deliverWebhook(ctx context.Context, hook Webhook, payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not encode webhook payload", "webhook_id", hook.ID, "err", err)
+		return
+	}
+
+	delay := webhookInitialDelay
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		delivery := WebhookDelivery{
+			PayloadID: payload.ID,
+			Event:     payload.Event,
+			Attempt:   attempt,
+			AttemptAt: time.Now().UTC(),
+		}
+		statusCode, err := h.postWebhook(ctx, hook, payload.Event, body)
+		delivery.DurationMS = time.Since(delivery.AttemptAt).Milliseconds()
+		delivery.StatusCode = statusCode
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.Success = err == nil && statusCode >= 200 && statusCode < 300
+		h.logWebhookDelivery(ctx, hook.ID, delivery)
+		if delivery.Success {
+			return
+		}
+
+		if attempt < webhookMaxAttempts {
+			if !sleepContext(h.jobs.ctx, delay) {
+				slog.InfoContext(ctx, "stopped retrying webhook for shutdown", "webhook_id", hook.ID, "event", payload.Event, "attempts", attempt)
+				return
+			}
+			delay *= 2
+		}
+	}
+	slog.WarnContext(ctx, "giving up on webhook delivery", "webhook_id", hook.ID, "event", payload.Event, "attempts", webhookMaxAttempts)
+}
+
+func (h *Handlers) postWebhook(ctx context.Context, hook Webhook, event string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(hook.Secret, timestamp, body))
+
+	resp, err := h.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+/* ------------------ Handlers ------------------ */
+
+func (h *Handlers) CreateWebhook(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req CreateWebhookRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return apierr.New(apierr.CodeInvalidRequest, "Invalid request body")
+	}
+	if err := validateWebhookURL(ctx, req.URL, h.allowPrivateWebhooks); err != nil {
+		return err
+	}
+	if len(req.Events) == 0 {
+		req.Events = webhookEvents
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return apierr.New(apierr.CodeInvalidRequest, fmt.Sprintf("Unknown event %q", event))
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return apierr.New(apierr.CodeInternal, "Failed to generate secret")
+	}
+	hook := &Webhook{
+		ID:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		UserID:    user.ID,
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.saveWebhook(ctx, hook); err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to save webhook")
+	}
+	return c.Status(fiber.StatusCreated).JSON(hook)
+}
+
+func (h *Handlers) GetWebhooks(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := h.getUserWebhooks(ctx, user.ID)
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to load webhooks")
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return c.Status(fiber.StatusOK).JSON(hooks)
+}
+
+// userWebhook loads the webhook in the :id param if it belongs to the user
+func (h *Handlers) userWebhook(c fiber.Ctx) (*Webhook, error) {
+	ctx := middleware.Context(c)
+	user, err := middleware.CurrentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	hook, err := h.getWebhook(ctx, c.Params("id"))
+	if err == redis.Nil || (err == nil && hook.UserID != user.ID) {
+		return nil, apierr.New(apierr.CodeWebhookNotFound, "Webhook not found")
+	}
+	if err != nil {
+		return nil, apierr.Wrap(err, apierr.CodeInternal, "Failed to load webhook")
+	}
+	return hook, nil
+}
+
+func (h *Handlers) DeleteWebhook(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	hook, err := h.userWebhook(c)
+	if err != nil {
+		return err
+	}
+	if err := h.deleteWebhook(ctx, hook); err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to delete webhook")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Webhook deleted"})
+}
+
+func (h *Handlers) GetWebhookDeliveries(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	hook, err := h.userWebhook(c)
+	if err != nil {
+		return err
+	}
+	entries, err := h.redis.LRange(ctx, webhookDeliveriesKey(hook.ID), 0, -1).Result()
+	if err != nil {
+		return apierr.Wrap(err, apierr.CodeInternal, "Failed to load deliveries")
+	}
+	deliveries := make([]WebhookDelivery, 0, len(entries))
+	for _, entry := range entries {
+		var delivery WebhookDelivery
+		if err := json.Unmarshal([]byte(entry), &delivery); err == nil {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return c.Status(fiber.StatusOK).JSON(deliveries)
+}
+
+// PingWebhook sends a test event, so receivers can be checked without running a build
+func (h *Handlers) PingWebhook(c fiber.Ctx) error {
+	ctx := middleware.Context(c)
+	hook, err := h.userWebhook(c)
+	if err != nil {
+		return err
+	}
+	payload := newWebhookPayload(WebhookEventPing, fiber.Map{"webhook_id": hook.ID})
+	h.jobs.run(ctx, jobWebhookDelivery, func(ctx context.Context) { h.deliverWebhook(ctx, *hook, payload) })
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Ping queued", "payload_id": payload.ID})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	body := []byte(`{"event":"ping"}`)
+	// openssl dgst -sha256 -hmac whsec_test over "1700000000.{body}"
+	const want = "sha256=aa8efe37b751e71157c508c5ac4acb1e9fe5225db98355dfc00f4b680afbc447"
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+		match     bool
+	}{
+		{"same input", "whsec_test", "1700000000", body, true},
+		{"other secret", "whsec_other", "1700000000", body, false},
+		{"other timestamp", "whsec_test", "1700000001", body, false},
+		{"other body", "whsec_test", "1700000000", []byte(`{"event":"pong"}`), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SignWebhookPayload(tt.secret, tt.timestamp, tt.body)
+			if (got == want) != tt.match {
+				t.Errorf("SignWebhookPayload() = %s, match with %s should be %v", got, want, tt.match)
+			}
+		})
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false}, // carrier-grade NAT
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::7f00:1", false}, // NAT64 of 127.0.0.1
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	for _, allowPrivate := range []bool{false, true} {
+		name := "refused"
+		if allowPrivate {
+			name = "allowed"
+		}
+		t.Run(name, func(t *testing.T) {
+			err := validateWebhookURL(context.Background(), receiver.URL, allowPrivate)
+			if (err == nil) != allowPrivate {
+				t.Errorf("validateWebhookURL(%s) = %v, want allowed %v", receiver.URL, err, allowPrivate)
+			}
+
+			// The dial check refuses on its own, whatever passed validation
+			resp, err := newWebhookClient(allowPrivate).Post(receiver.URL, "application/json", nil)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err == nil) != allowPrivate {
+				t.Errorf("delivery to %s: %v, want allowed %v", receiver.URL, err, allowPrivate)
+			}
+		})
+	}
+}
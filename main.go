@@ -1,8 +1,40 @@
 package main
 
 import (
-	_ "app/config"
-	_ "app/router"
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"app/config"
+	"app/logging"
+	"app/server"
 )
 
-func main() {}
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	logger, err := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Also routes the standard log package, so nothing is left unstructured
+	slog.SetDefault(logger)
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		slog.Error("startup failed", "err", err)
+		os.Exit(1)
+	}
+	// SIGTERM is what container runtimes send on deploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Run(ctx); err != nil {
+		slog.Error("server stopped with an error", "err", err)
+		os.Exit(1)
+	}
+}
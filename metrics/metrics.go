@@ -0,0 +1,102 @@
+// Package metrics defines the Prometheus metrics served on /metrics
+package metrics
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"app/apierr"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "playmaker"
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to handle HTTP requests, by route and status.",
+		// Playlist builds crawl whole discographies and can take a minute
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"method", "route", "status"})
+
+	SpotifyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spotify_requests_total",
+		Help:      "Calls to the Spotify API, by endpoint and status. Status is \"error\" if no response arrived.",
+	}, []string{"method", "endpoint", "status"})
+
+	SpotifyRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "spotify_request_duration_seconds",
+		Help:      "Latency of calls to the Spotify API, by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "endpoint"})
+
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups by key type and result (hit or miss).",
+	}, []string{"kind", "result"})
+
+	CacheCorrupt = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_corrupt_total",
+		Help:      "Cache entries that could not be decoded, by key type. These also count as misses.",
+	}, []string{"kind"})
+
+	BackgroundJobs = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "background_jobs",
+		Help:      "Background jobs currently running, by job.",
+	}, []string{"job"})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by a rate limit, by route and scope (user or ip). Route is \"*\" for the limit on all requests.",
+	}, []string{"route", "scope"})
+)
+
+// Middleware records the duration and status of every request. Routes are labelled with their
+// pattern, e.g. /playlist/:id/history, so IDs don't multiply the series.
+func Middleware(c fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		// The error handler sets the status after this middleware returns
+		status = apierr.Status(err)
+	}
+	httpRequestDuration.WithLabelValues(c.Method(), c.Route().Path, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// idParents are the path segments followed by an ID in Spotify API paths
+var idParents = map[string]bool{"artists": true, "albums": true, "playlists": true, "users": true, "tracks": true}
+
+// spotifyHosts are the hosts whose paths are used as endpoint labels
+var spotifyHosts = map[string]bool{"api.spotify.com": true, "accounts.spotify.com": true}
+
+// SpotifyEndpoint turns a Spotify URL into a label, e.g. /v1/artists/{id}/albums. Other hosts,
+// like the CDN serving cover images at a path per image, are all labelled "image".
+func SpotifyEndpoint(u *url.URL) string {
+	if !spotifyHosts[u.Hostname()] {
+		return "image"
+	}
+	segments := strings.Split(u.Path, "/")
+	for i := 1; i < len(segments); i++ {
+		if idParents[segments[i-1]] && segments[i] != "" {
+			// /v1/playlists/{id}/tracks: "tracks" is an endpoint, not a parent
+			segments[i] = "{id}"
+			i++
+		}
+	}
+	return strings.Join(segments, "/")
+}
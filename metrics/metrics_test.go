@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSpotifyEndpoint(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.spotify.com/v1/me", "/v1/me"},
+		{"https://api.spotify.com/v1/artists/4Z8W4fKeB5YxbusRsdQVPb/albums?limit=50", "/v1/artists/{id}/albums"},
+		{"https://api.spotify.com/v1/albums/1?market=DE", "/v1/albums/{id}"},
+		{"https://api.spotify.com/v1/albums", "/v1/albums"},
+		{"https://api.spotify.com/v1/playlists/abc/tracks", "/v1/playlists/{id}/tracks"},
+		{"https://api.spotify.com/v1/playlists/abc/images", "/v1/playlists/{id}/images"},
+		{"https://api.spotify.com/v1/users/someone/playlists", "/v1/users/{id}/playlists"},
+		{"https://api.spotify.com/v1/tracks/abc", "/v1/tracks/{id}"},
+		{"https://accounts.spotify.com/api/token", "/api/token"},
+		{"https://i.scdn.co/image/ab6761610000e5eb", "image"},
+		{"https://mosaic.scdn.co/640/abc", "image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := SpotifyEndpoint(u); got != tt.want {
+				t.Errorf("SpotifyEndpoint() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"app/apierr"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+// IsAdmin lets the request through if it carries "Authorization: Bearer <adminToken>". Every
+// request is rejected if adminToken is empty.
+func IsAdmin(adminToken string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if adminToken == "" {
+			return apierr.New(apierr.CodeForbidden, "Admin API is disabled")
+		}
+
+		token, found := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			return apierr.New(apierr.CodeUnauthorized, "Invalid admin token")
+		}
+		return c.Next()
+	}
+}
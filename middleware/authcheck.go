@@ -2,9 +2,14 @@ package middleware
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
+	"time"
+
+	"app/apierr"
+	"app/logging"
 
 	utils "github.com/ItsMeSamey/go_utils"
 	"github.com/gofiber/fiber/v3"
@@ -23,70 +28,72 @@ func extractID(jsonresponse []byte) (string, error) {
 	if id, ok := data["id"].(string); ok {
 		return id, nil
 	}
-	return "", utils.WithStack(fiber.NewError(fiber.StatusInternalServerError, "ID not found in response"))
+	return "", utils.WithStack(errors.New("ID not found in response"))
 }
 
-func IsAuthenticated(c fiber.Ctx) error {
-    authHeader := c.Get("Authorization")
-
-    if authHeader == "" {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-            "error": "Missing Authorization Header",
-        })
-    }
-
-    parts := strings.Split(authHeader, " ")
-    if len(parts) != 2 || parts[0] != "Bearer" {
-        return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-            "error": "Invalid Authorization Header format",
-        })
-    }
-    token := parts[1]
-
-	req, err := http.NewRequest("GET", "https://api.spotify.com/v1/me", nil)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
+// IsAuthenticated checks the bearer token against Spotify's /v1/me and stores the user for
+// CurrentUser. client is used for the check, or a client with a 10 second timeout if nil.
+func IsAuthenticated(client *http.Client) fiber.Handler {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
 	}
+	return func(c fiber.Ctx) error {
+		authHeader := c.Get("Authorization")
 
-	req.Header.Add("Authorization", "Bearer "+token)
+		if authHeader == "" {
+			return apierr.New(apierr.CodeUnauthorized, "Missing Authorization Header")
+		}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
-	}
-	defer resp.Body.Close() // Move defer to right after getting response
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return apierr.New(apierr.CodeUnauthorized, "Invalid Authorization Header format")
+		}
+		token := parts[1]
 
-	if resp.StatusCode != http.StatusOK {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or expired token",
-		})
-	}
+		req, err := http.NewRequestWithContext(Context(c), "GET", "https://api.spotify.com/v1/me", nil)
+		if err != nil {
+			return apierr.Wrap(err, apierr.CodeInternal, "Failed to check token")
+		}
 
-	// Fixed body reading - use io.ReadAll instead
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
-	}
+		req.Header.Add("Authorization", "Bearer "+token)
 
-	id, err := extractID(bodyBytes)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": utils.WithStack(err),
-		})
-	}
-	user := User{
-		ID:    id,
-		TOKEN: token,
-	}
+		resp, err := client.Do(req)
+		if err != nil {
+			return apierr.Wrap(err, apierr.CodeSpotifyError, "Failed to reach Spotify")
+		}
+		defer resp.Body.Close() // Move defer to right after getting response
+
+		if resp.StatusCode != http.StatusOK {
+			return apierr.FromSpotify(resp)
+		}
 
-    c.Locals("user", user)
+		// Fixed body reading - use io.ReadAll instead
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return apierr.Wrap(err, apierr.CodeSpotifyError, "Failed to read Spotify response")
+		}
 
-    return c.Next()
-}
\ No newline at end of file
+		id, err := extractID(bodyBytes)
+		if err != nil {
+			return apierr.Wrap(err, apierr.CodeSpotifyError, "Unexpected Spotify response")
+		}
+		user := User{
+			ID:    id,
+			TOKEN: token,
+		}
+
+		c.Locals("user", user)
+		setContext(c, logging.With(Context(c), "user_id", user.ID))
+
+		return c.Next()
+	}
+}
+
+// CurrentUser returns the user IsAuthenticated stored for the request
+func CurrentUser(c fiber.Ctx) (User, error) {
+	user, ok := c.Locals("user").(User)
+	if !ok || user.TOKEN == "" || user.ID == "" {
+		return User{}, apierr.New(apierr.CodeUnauthorized, "User not authenticated")
+	}
+	return user, nil
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"strconv"
+
+	"app/apierr"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+type errorBody struct {
+	Code      apierr.Code `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// ErrorHandler is the app's fiber ErrorHandler. It writes every error as
+// {"error": {"code", "message", "request_id"}}. The cause, stack trace included, is only logged.
+func ErrorHandler(c fiber.Ctx, err error) error {
+	apiErr := apierr.From(err)
+	status := apierr.Status(err)
+
+	ctx := Context(c)
+	if status >= fiber.StatusInternalServerError {
+		slog.ErrorContext(ctx, "request failed", "code", apiErr.Code, "err", err)
+	} else if apiErr.Err != nil {
+		slog.DebugContext(ctx, "request rejected", "code", apiErr.Code, "err", err)
+	}
+
+	if apiErr.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+	return c.Status(status).JSON(fiber.Map{"error": errorBody{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		RequestID: c.GetRespHeader(RequestIDHeader),
+	}})
+}
+
+// LogPanic is the recover middleware's StackTraceHandler. The panic then reaches ErrorHandler
+// as an internal error.
+func LogPanic(c fiber.Ctx, e any) {
+	slog.ErrorContext(Context(c), "panic", "panic", e, "stack", string(debug.Stack()))
+}
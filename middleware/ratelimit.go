@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"app/apierr"
+	"app/config"
+	"app/metrics"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v3"
+)
+
+// rateLimitScript counts a request in a fixed window and returns the count and the
+// milliseconds left in the window. The window starts with the first request.
+var rateLimitScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+const (
+	// rateLimitTimeout bounds each counter update, well below the go-redis dial and retry timeouts
+	rateLimitTimeout = 200 * time.Millisecond
+	// rateLimitPause is how long checks are skipped after Redis fails, so an outage costs one
+	// timeout and one log line per pause rather than per request
+	rateLimitPause = 10 * time.Second
+)
+
+// RateLimiter enforces config.RateLimitConfig with counters in Redis
+type RateLimiter struct {
+	client *redis.Client
+	cfg    config.RateLimitConfig
+	// pausedUntil is the unix nano time until which checks are skipped after a Redis failure
+	pausedUntil atomic.Int64
+}
+
+func NewRateLimiter(client *redis.Client, cfg config.RateLimitConfig) *RateLimiter {
+	return &RateLimiter{client: client, cfg: cfg}
+}
+
+// All applies the per-IP limit on every request. It goes before authentication, so requests
+// with bad tokens count too.
+func (l *RateLimiter) All(c fiber.Ctx) error {
+	if !l.cfg.Enabled {
+		return c.Next()
+	}
+	if err := l.check(c, "*", "ip", c.IP(), l.cfg.PerIP); err != nil {
+		return err
+	}
+	return c.Next()
+}
+
+// Route applies the limits configured for the matched route. It goes after IsAuthenticated,
+// the per-user limit needs the user.
+func (l *RateLimiter) Route(c fiber.Ctx) error {
+	if !l.cfg.Enabled {
+		return c.Next()
+	}
+	route := c.Method() + " " + c.Route().Path
+	limits, ok := l.cfg.Routes[route]
+	if !ok {
+		return c.Next()
+	}
+	if user, ok := c.Locals("user").(User); ok && user.ID != "" {
+		if err := l.check(c, route, "user", user.ID, limits.PerUser); err != nil {
+			return err
+		}
+	}
+	if err := l.check(c, route, "ip", c.IP(), limits.PerIP); err != nil {
+		return err
+	}
+	return c.Next()
+}
+
+// check counts the request against limit and returns a rate_limited error once it is used up.
+// If Redis fails the request is let through, an outage of the limiter should not take the API down,
+// and checks are paused for rateLimitPause.
+func (l *RateLimiter) check(c fiber.Ctx, route, scope, id string, limit config.RateLimit) error {
+	if limit.Requests <= 0 || time.Now().UnixNano() < l.pausedUntil.Load() {
+		return nil
+	}
+	ctx := Context(c)
+	count, ttl, err := l.count(ctx, rateLimitKey(route, scope, id), limit.Window)
+	if err != nil {
+		now := time.Now()
+		if l.pausedUntil.Swap(now.Add(rateLimitPause).UnixNano()) > now.UnixNano() {
+			return nil // a concurrent check already paused and logged
+		}
+		slog.WarnContext(ctx, "rate limit check failed, not limiting requests for a while",
+			"route", route, "scope", scope, "pause", rateLimitPause.String(), "err", err)
+		return nil
+	}
+	if count <= int64(limit.Requests) {
+		return nil
+	}
+
+	metrics.RateLimited.WithLabelValues(route, scope).Inc()
+	slog.InfoContext(ctx, "rate limited", "route", route, "scope", scope, "limit", limit.Requests, "window", limit.Window.String())
+	return &apierr.Error{
+		Code:       apierr.CodeRateLimited,
+		Message:    "Too many requests, try again later",
+		RetryAfter: ttl,
+	}
+}
+
+func (l *RateLimiter) count(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
+	defer cancel()
+	res, err := rateLimitScript.Run(ctx, l.client, []string{key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(res) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+	return res[0], time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func rateLimitKey(route, scope, id string) string {
+	return fmt.Sprintf("rate_limit:%s:%s:%s", scope, id, route)
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"app/config"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v3"
+)
+
+// countingHook counts the commands sent to Redis
+type countingHook struct{ commands atomic.Int64 }
+
+func (h *countingHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	h.commands.Add(1)
+	return ctx, nil
+}
+func (h *countingHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+func (h *countingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	h.commands.Add(int64(len(cmds)))
+	return ctx, nil
+}
+func (h *countingHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+// newLimitedApp serves POST /playlist/create behind limiter, as user X-User if the header is set
+func newLimitedApp(limiter *RateLimiter) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(limiter.All)
+	setUser := func(c fiber.Ctx) error {
+		if id := c.Get("X-User"); id != "" {
+			c.Locals("user", User{ID: id})
+		}
+		return c.Next()
+	}
+	app.Post("/playlist/create", setUser, limiter.Route, func(c fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return app
+}
+
+func send(t *testing.T, app *fiber.App, user string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/playlist/create", nil)
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	resp, err := app.Test(req, fiber.TestConfig{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestRateLimiterFailsOpen(t *testing.T) {
+	// Accepts connections but never answers, like an overloaded Redis
+	hung, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hung.Close()
+	go func() {
+		for {
+			conn, err := hung.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	// Nothing listens on a port we just closed
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	limits := config.RateLimitConfig{
+		Enabled: true,
+		PerIP:   config.RateLimit{Requests: 1, Window: time.Minute},
+		Routes: map[string]config.RouteRateLimit{
+			"POST /playlist/create": {PerUser: config.RateLimit{Requests: 1, Window: time.Minute}},
+		},
+	}
+	tests := []struct {
+		name         string
+		addr         string
+		limits       config.RateLimitConfig
+		wantCommands int64
+	}{
+		{"disabled", closedAddr, config.RateLimitConfig{Enabled: false, PerIP: limits.PerIP, Routes: limits.Routes}, 0},
+		{"redis down", closedAddr, limits, 1},
+		{"redis hanging", hung.Addr().String(), limits, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := redis.NewClient(&redis.Options{Addr: tt.addr, MaxRetries: -1})
+			defer client.Close()
+			hook := &countingHook{}
+			client.AddHook(hook)
+			app := newLimitedApp(NewRateLimiter(client, tt.limits))
+
+			for i := 0; i < 3; i++ {
+				start := time.Now()
+				resp := send(t, app, "user")
+				if resp.StatusCode != fiber.StatusNoContent {
+					t.Errorf("request %d: status %d, want %d", i, resp.StatusCode, fiber.StatusNoContent)
+				}
+				if elapsed := time.Since(start); elapsed > time.Second {
+					t.Errorf("request %d took %s", i, elapsed)
+				}
+			}
+			// After the first failure checks are paused instead of waiting on Redis every time
+			if got := hook.commands.Load(); got != tt.wantCommands {
+				t.Errorf("sent %d commands to Redis, want %d", got, tt.wantCommands)
+			}
+		})
+	}
+}
+
+// TestRateLimitScript runs against the Redis at TEST_REDIS_ADDR, e.g. one started with
+// docker compose up redis
+func TestRateLimitScript(t *testing.T) {
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR is not set")
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatalf("redis at %s: %v", addr, err)
+	}
+
+	const route = "POST /playlist/create"
+	user := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	other := user + "-other"
+	defer client.Del(ctx, rateLimitKey(route, "user", user), rateLimitKey(route, "user", other))
+
+	limiter := NewRateLimiter(client, config.RateLimitConfig{
+		Enabled: true,
+		Routes: map[string]config.RouteRateLimit{
+			route: {PerUser: config.RateLimit{Requests: 2, Window: time.Minute}},
+		},
+	})
+	app := newLimitedApp(limiter)
+
+	tests := []struct {
+		user       string
+		wantStatus int
+	}{
+		{user, fiber.StatusNoContent},
+		{user, fiber.StatusNoContent},
+		{user, fiber.StatusTooManyRequests},
+		{other, fiber.StatusNoContent},
+		{user, fiber.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		resp := send(t, app, tt.user)
+		if resp.StatusCode != tt.wantStatus {
+			t.Fatalf("request %d: status %d, want %d", i, resp.StatusCode, tt.wantStatus)
+		}
+		if tt.wantStatus == fiber.StatusTooManyRequests {
+			if retryAfter := resp.Header.Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+				t.Errorf("request %d: Retry-After = %q, want the rest of the window", i, retryAfter)
+			}
+		}
+	}
+
+	// The window starts with the first request and isn't extended by later ones
+	ttl, err := client.PTTL(ctx, rateLimitKey(route, "user", user)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("counter ttl = %s, want at most a minute", ttl)
+	}
+}
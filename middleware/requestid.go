@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+
+	"app/apierr"
+	"app/logging"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestContextKey struct{}
+
+// Incoming request IDs are kept only if they are short and plain, so they can't forge log fields
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Context returns the request's context, which carries its log attributes. Unlike the fiber.Ctx
+// it stays valid after the handler returns, so background work can keep using it.
+func Context(c fiber.Ctx) context.Context {
+	if ctx, ok := c.Locals(requestContextKey{}).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+func setContext(c fiber.Ctx, ctx context.Context) {
+	c.Locals(requestContextKey{}, ctx)
+}
+
+// RequestID takes the request ID from the X-Request-ID header, or makes one up, echoes it in the
+// response and adds it to every log line of the request
+func RequestID(c fiber.Ctx) error {
+	id := c.Get(RequestIDHeader)
+	if !validRequestID.MatchString(id) {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	c.Set(RequestIDHeader, id)
+	setContext(c, logging.With(context.Background(), "request_id", id))
+	return c.Next()
+}
+
+// AccessLog logs every request once it has been handled. It goes after RequestID.
+func AccessLog(c fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := responseStatus(c, err)
+	level := slog.LevelInfo
+	if status >= fiber.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	slog.Log(Context(c), level, "request",
+		"method", c.Method(),
+		"path", c.Path(),
+		"route", c.Route().Path,
+		"status", status,
+		"duration_ms", time.Since(start).Milliseconds(),
+		"ip", c.IP(),
+	)
+	return err
+}
+
+// responseStatus is the status the client gets once the error handler has seen err
+func responseStatus(c fiber.Ctx, err error) int {
+	if err != nil {
+		return apierr.Status(err)
+	}
+	return c.Response().StatusCode()
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"app/logging"
+	"app/tracing"
+
+	"github.com/gofiber/fiber/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a span for each request, continuing the caller's trace if it sent a
+// traceparent header, and adds the trace ID to the request's log lines. It goes after RequestID.
+func Tracing(c fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(Context(c), propagation.HeaderCarrier(http.Header(c.GetReqHeaders())))
+	ctx, span := tracing.Tracer.Start(ctx, c.Method()+" "+c.Path(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Method()),
+			semconv.URLPath(c.Path()),
+			semconv.ClientAddress(c.IP()),
+		),
+	)
+	defer span.End()
+	if span.SpanContext().IsValid() {
+		ctx = logging.With(ctx, "trace_id", span.SpanContext().TraceID().String())
+	}
+	setContext(c, ctx)
+
+	err := c.Next()
+
+	// The route is only known once the request has been matched
+	route := c.Route().Path
+	span.SetName(c.Method() + " " + route)
+	status := responseStatus(c, err)
+	span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	return err
+}
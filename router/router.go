@@ -0,0 +1,113 @@
+package router
+
+import (
+	"app/config"
+	"app/handlers"
+	"app/metrics"
+	"app/middleware"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	utils "github.com/ItsMeSamey/go_utils"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/adaptor"
+	"github.com/gofiber/fiber/v3/middleware/cors"
+	fiberRecover "github.com/gofiber/fiber/v3/middleware/recover"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// New builds the app with every route registered on h. Rate limit counters are kept in redisClient.
+func New(cfg *config.Config, h *handlers.Handlers, redisClient *redis.Client) (app *fiber.App, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = utils.WithStack(errors.New("Error initializing router: " + fmt.Sprint(r)))
+		}
+	}()
+	// Fiber trusts ProxyHeader from everyone unless TrustProxy is set, so only read it behind
+	// configured proxies
+	var proxyHeader string
+	if len(cfg.TrustedProxies) > 0 {
+		proxyHeader = cfg.ProxyHeader
+	}
+	app = fiber.New(fiber.Config{
+		CaseSensitive:      true,
+		Concurrency:        1024 * 1024,
+		IdleTimeout:        30 * time.Second,
+		DisableDefaultDate: true,
+		JSONEncoder:        json.Marshal,
+		JSONDecoder:        json.Unmarshal,
+		BodyLimit:          100 * 1024 * 1024,
+		ErrorHandler:       middleware.ErrorHandler,
+		TrustProxy:         len(cfg.TrustedProxies) > 0,
+		TrustProxyConfig:   fiber.TrustProxyConfig{Proxies: cfg.TrustedProxies},
+		ProxyHeader:        proxyHeader,
+		EnableIPValidation: true,
+	})
+
+	app.Use(middleware.RequestID, middleware.Tracing, middleware.AccessLog)
+	app.Use(cors.New(cors.Config{
+        AllowOrigins:     cfg.CORSOrigins,
+        AllowMethods:     []string{"GET", "POST", "HEAD", "PUT", "DELETE", "PATCH", "OPTIONS"},
+        AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", middleware.RequestIDHeader, "traceparent", "tracestate"},
+        ExposeHeaders:    []string{middleware.RequestIDHeader, fiber.HeaderRetryAfter},
+        AllowCredentials: true,
+    }))
+
+	app.Use(fiberRecover.New(fiberRecover.Config{EnableStackTrace: true, StackTraceHandler: middleware.LogPanic}))
+	app.Use(metrics.Middleware)
+	
+
+	utils.SetErrorStackTrace(true)	
+
+	app.Get("/healthz", h.Healthz)
+	app.Get("/readyz", h.Readyz)
+	// Scrapers authenticate with the admin token, as a bearer token
+	app.Get("/metrics", middleware.IsAdmin(cfg.AdminToken), adaptor.HTTPHandler(promhttp.Handler()))
+
+	// Registered after the probes and /metrics, which don't call Next, so they are not limited
+	auth := middleware.IsAuthenticated(h.SpotifyClient())
+	limits := middleware.NewRateLimiter(redisClient, cfg.RateLimit)
+	app.Use(limits.All)
+	app.Post("/login", limits.Route, h.Login)
+	app.Get("/playlists", auth, limits.Route, h.GetUserPlaylists)
+	app.Post("/playlist/create", auth, limits.Route, h.CreatePlaylist)
+	app.Post("/playlist/modify", auth, limits.Route, h.ModifyPlaylist)
+	app.Get("/playlist/:id/history", auth, limits.Route, h.GetPlaylistHistory)
+	app.Post("/playlist/:id/restore", auth, limits.Route, h.RestorePlaylist)
+	app.Post("/subscriptions", auth, limits.Route, h.CreateSubscription)
+	app.Get("/subscriptions", auth, limits.Route, h.GetSubscriptions)
+	app.Delete("/subscriptions/:id", auth, limits.Route, h.DeleteSubscription)
+	app.Get("/artists/:id/releases", auth, limits.Route, h.GetArtistReleases)
+	app.Post("/webhooks", auth, limits.Route, h.CreateWebhook)
+	app.Get("/webhooks", auth, limits.Route, h.GetWebhooks)
+	app.Delete("/webhooks/:id", auth, limits.Route, h.DeleteWebhook)
+	app.Get("/webhooks/:id/deliveries", auth, limits.Route, h.GetWebhookDeliveries)
+	app.Post("/webhooks/:id/ping", auth, limits.Route, h.PingWebhook)
+	admin := app.Group("/admin", middleware.IsAdmin(cfg.AdminToken))
+	admin.Get("/cache/artists", h.GetCachedArtists)
+	admin.Delete("/cache/artists/:id", h.InvalidateArtistCache)
+	admin.Delete("/cache", h.InvalidateCache)
+	admin.Post("/cache/warm", h.WarmCache)
+	admin.Get("/cache/stats", h.GetCacheStats)
+	app.Get("/test", func(c fiber.Ctx) error {
+        slog.DebugContext(middleware.Context(c), "test route called")
+        return c.SendString("Test route works")
+    })
+
+	registered := map[string]bool{}
+	for _, route := range app.GetRoutes(true) {
+		registered[route.Method+" "+route.Path] = true
+	}
+	for route := range cfg.RateLimit.Routes {
+		if !registered[route] {
+			slog.Warn("rate limit configured for an unknown route", "route", route)
+		}
+	}
+
+	slog.Info("routes initialized")
+	return app, nil
+}
\ No newline at end of file
@@ -0,0 +1,112 @@
+// Package server wires the configuration, Redis, the Spotify client and the routes together
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"app/cache"
+	"app/config"
+	"app/handlers"
+	"app/router"
+	"app/tracing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v3"
+)
+
+const spotifyTimeout = 10 * time.Second
+
+type Server struct {
+	cfg         *config.Config
+	app         *fiber.App
+	handlers    *handlers.Handlers
+	jobs        *handlers.Jobs
+	redis       *redis.Client
+	cache       cache.Cache
+	stopTracing func(context.Context) error
+}
+
+// New sets up tracing, connects to Redis and sets up the handlers and routes, but starts nothing
+func New(cfg *config.Config) (s *Server, err error) {
+	stopTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.OTLPEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, stopTracing(context.Background()))
+		}
+	}()
+	redisClient, c, err := config.Connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, redisClient.Close())
+		}
+	}()
+	jobs := handlers.NewJobs()
+	h := handlers.New(cfg, redisClient, c, &http.Client{Timeout: spotifyTimeout}, jobs)
+
+	app, err := router.New(cfg, h, redisClient)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{cfg: cfg, app: app, handlers: h, jobs: jobs, redis: redisClient, cache: c, stopTracing: stopTracing}, nil
+}
+
+// Run starts the background jobs and serves requests until ctx is done or the listener fails,
+// then shuts down
+func (s *Server) Run(ctx context.Context) error {
+	// Background jobs
+	s.handlers.StartSubscriptionScheduler(s.cfg.Subscriptions.CheckInterval)
+
+	errs := make(chan error, 1)
+	go func() {
+		// The startup banner isn't structured, so log the address ourselves
+		slog.Info("listening", "port", s.cfg.Port)
+		errs <- s.app.Listen(fmt.Sprintf(":%d", s.cfg.Port), fiber.ListenConfig{
+			DisableStartupMessage: true,
+		})
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Join(err, s.shutdown())
+	case <-ctx.Done():
+		return s.shutdown()
+	}
+}
+
+// shutdown stops accepting requests, waits up to ShutdownTimeout for in-flight requests (playlist
+// builds included) and background jobs, then closes Redis and flushes traces
+func (s *Server) shutdown() error {
+	slog.Info("shutting down, waiting for in-flight requests and background jobs", "timeout", s.cfg.ShutdownTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := s.app.ShutdownWithContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("drain requests: %w", err))
+	}
+	if err := s.jobs.Drain(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("drain background jobs: %w", err))
+	}
+	if err := s.redis.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close Redis: %w", err))
+	}
+	// Last, so the spans of the drained requests and jobs are exported
+	if err := s.stopTracing(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("flush traces: %w", err))
+	}
+	if len(errs) == 0 {
+		slog.Info("shutdown complete")
+	}
+	return errors.Join(errs...)
+}